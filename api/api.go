@@ -0,0 +1,134 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Botond24/CraftyProxy/crafty"
+)
+
+type API struct {
+	crafty *crafty.Crafty
+	key    string
+	logger *log.Logger
+	mux    *http.ServeMux
+}
+
+type serverResponse struct {
+	Name        string `json:"name"`
+	State       string `json:"state"`
+	Players     int    `json:"players"`
+	Maintenance bool   `json:"maintenance"`
+	Port        uint16 `json:"port"`
+	AutoOn      bool   `json:"auto_on"`
+	AutoOff     bool   `json:"auto_off"`
+}
+
+func New(c *crafty.Crafty, key string) *API {
+	a := new(API)
+	a.crafty = c
+	a.key = key
+	a.logger = log.New(os.Stdout, "api: ", log.Ldate|log.Ltime)
+	a.mux = http.NewServeMux()
+	a.mux.HandleFunc("GET /api/servers", a.servers)
+	a.mux.HandleFunc("POST /api/servers/{name}/maintenance", a.maintenance)
+	a.mux.HandleFunc("GET /api/servers/{name}/predictions", a.predictions)
+	return a
+}
+
+// Serve listens for API requests until the listener fails. Without a key it refuses to serve,
+// as the API can put servers into maintenance and shows when they are used.
+func (a *API) Serve(addr string) {
+	if a.key == "" {
+		a.logger.Println("Not serving the API, it needs a key")
+		return
+	}
+	a.logger.Println("API listening on " + addr)
+	err := http.ListenAndServe(addr, a)
+	if err != nil {
+		a.logger.Println("API stopped: " + err.Error())
+	}
+}
+
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	given := []byte(r.Header.Get("Authorization"))
+	if a.key == "" || subtle.ConstantTimeCompare(given, []byte("Bearer "+a.key)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	a.mux.ServeHTTP(w, r)
+}
+
+func (a *API) servers(w http.ResponseWriter, r *http.Request) {
+	servers := make([]serverResponse, 0, len(a.crafty.Servers))
+	for _, s := range a.crafty.Servers {
+		servers = append(servers, newServerResponse(s))
+	}
+	writeJSON(w, servers)
+}
+
+func (a *API) maintenance(w http.ResponseWriter, r *http.Request) {
+	s := a.server(w, r)
+	if s == nil {
+		return
+	}
+	enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+	if err != nil {
+		http.Error(w, "enabled must be true or false", http.StatusBadRequest)
+		return
+	}
+	s.SetMaintenance(enabled, "API ("+r.RemoteAddr+")")
+	writeJSON(w, newServerResponse(s))
+}
+
+type predictionsResponse struct {
+	Server      string              `json:"server"`
+	Enabled     bool                `json:"enabled"`
+	Predictions []crafty.Prediction `json:"predictions"`
+}
+
+func (a *API) predictions(w http.ResponseWriter, r *http.Request) {
+	s := a.server(w, r)
+	if s == nil {
+		return
+	}
+	writeJSON(w, predictionsResponse{
+		Server:      s.Name,
+		Enabled:     s.Prewarm,
+		Predictions: s.History.Predictions(time.Now()),
+	})
+}
+
+// server looks up the server named in the path, answering 404 if there's none
+func (a *API) server(w http.ResponseWriter, r *http.Request) *crafty.Server {
+	s := a.crafty.Server(r.PathValue("name"))
+	if s == nil {
+		http.Error(w, "server not found", http.StatusNotFound)
+	}
+	return s
+}
+
+func newServerResponse(s *crafty.Server) serverResponse {
+	return serverResponse{
+		Name:        s.Name,
+		State:       s.State,
+		Players:     s.Players(),
+		Maintenance: s.InMaintenance(),
+		Port:        s.OutPort,
+		AutoOn:      s.AutoOn,
+		AutoOff:     s.AutoOff,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -1,16 +1,56 @@
 package main
 
 import (
+	"cmp"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Botond24/CraftyProxy/crafty"
 )
 
 type Config struct {
-	Addr       string
-	CraftyAddr string
-	Port       int
-	Key        string
-	Timeout    int
+	Addr         string
+	CraftyAddr   string
+	Port         int
+	Key          string
+	Timeout      int
+	KeepAlive    time.Duration
+	NoDelay      bool
+	DataDir      string
+	QuotaMessage string
+	Admins       []string
+	Maintenance  string
+	MaintMessage string
+	MaintIcon    string
+	ApiAddr      string
+	ApiKey       string
+	RulesFile    string
+	Priority     []string
+	QueueTimeout time.Duration
+	WakeGrace    time.Duration
+	AfkTimeout   time.Duration
+	AfkBytes     int64
+	StopWarnings []time.Duration
+	KillTimeout  time.Duration
+	StartupTime  time.Duration
+	ScheduleFile string
+	RunningCaps  []crafty.RunningCap
+	MaxHostCpu   float64
+	MaxHostMem   float64
+	AdmitWait    time.Duration
+	GroupsFile   string
+	PrewarmLead  time.Duration
+	PrewarmGrace time.Duration
+	PrewarmProb  float64
+	PolicyFile   string
+	PlaytimeFile string
+	HooksFile    string
+	NotifyFile   string
+	DiscordToken string
+	DiscordFile  string
 }
 
 var config *Config
@@ -40,5 +80,131 @@ func getConfig() Config {
 	if err != nil {
 		config.Timeout = 5
 	}
+	keepAlive, err := strconv.Atoi(os.Getenv("ProxyKeepAlive"))
+	if err != nil {
+		keepAlive = 15
+	}
+	config.KeepAlive = time.Duration(keepAlive) * time.Second
+	config.NoDelay, err = strconv.ParseBool(os.Getenv("ProxyNoDelay"))
+	if err != nil {
+		config.NoDelay = true
+	}
+	config.DataDir = os.Getenv("ProxyDataDir")
+	if config.DataDir == "" {
+		config.DataDir = "data"
+	}
+	err = os.MkdirAll(config.DataDir, 0o755)
+	if err != nil {
+		println("Can't create data directory " + config.DataDir + ", aborting...")
+		os.Exit(1)
+	}
+	config.QuotaMessage = os.Getenv("ProxyQuotaMessage")
+	config.Admins = splitList(os.Getenv("ProxyAdmins"))
+	config.Maintenance = os.Getenv("ProxyMaintenanceFile")
+	config.MaintMessage = os.Getenv("ProxyMaintenanceMessage")
+	config.MaintIcon = os.Getenv("ProxyMaintenanceIcon")
+	config.ApiAddr = os.Getenv("ProxyApiAddr")
+	config.ApiKey = os.Getenv("ProxyApiKey")
+	if config.ApiAddr != "" && config.ApiKey == "" {
+		println("ProxyApiKey is needed for the API, aborting...")
+		os.Exit(1)
+	}
+	config.RulesFile = os.Getenv("ProxyRulesFile")
+	config.Priority = splitList(os.Getenv("ProxyQueuePriority"))
+	queueTimeout, err := strconv.Atoi(os.Getenv("ProxyQueueTimeout"))
+	if err != nil {
+		queueTimeout = 10
+	}
+	config.QueueTimeout = time.Duration(queueTimeout) * time.Minute
+	wakeGrace, err := strconv.Atoi(os.Getenv("ProxyWakeGrace"))
+	if err != nil {
+		wakeGrace = config.Timeout
+	}
+	config.WakeGrace = time.Duration(wakeGrace) * time.Minute
+	afkTimeout, err := strconv.Atoi(os.Getenv("ProxyAfkTimeout"))
+	if err != nil {
+		afkTimeout = 0
+	}
+	config.AfkTimeout = time.Duration(afkTimeout) * time.Minute
+	config.AfkBytes, err = strconv.ParseInt(os.Getenv("ProxyAfkBytes"), 10, 64)
+	if err != nil {
+		config.AfkBytes = crafty.DefaultAfkBytes
+	}
+	for _, warning := range splitList(os.Getenv("ProxyStopWarnings")) {
+		seconds, err := strconv.Atoi(warning)
+		if err != nil {
+			println("ProxyStopWarnings must be a list of seconds, aborting...")
+			os.Exit(1)
+		}
+		config.StopWarnings = append(config.StopWarnings, time.Duration(seconds)*time.Second)
+	}
+	slices.SortFunc(config.StopWarnings, func(a, b time.Duration) int {
+		return cmp.Compare(b, a)
+	})
+	killTimeout, err := strconv.Atoi(os.Getenv("ProxyKillTimeout"))
+	if err != nil {
+		killTimeout = 60
+	}
+	config.KillTimeout = time.Duration(killTimeout) * time.Second
+	startupTimeout, err := strconv.Atoi(os.Getenv("ProxyStartupTimeout"))
+	if err != nil {
+		startupTimeout = 5
+	}
+	config.StartupTime = time.Duration(startupTimeout) * time.Minute
+	config.ScheduleFile = os.Getenv("ProxyScheduleFile")
+	config.GroupsFile = os.Getenv("ProxyGroupsFile")
+	config.PolicyFile = os.Getenv("ProxyPolicyFile")
+	config.PlaytimeFile = os.Getenv("ProxyPlaytimeFile")
+	config.HooksFile = os.Getenv("ProxyHooksFile")
+	config.NotifyFile = os.Getenv("ProxyNotifyFile")
+	config.DiscordToken = os.Getenv("ProxyDiscordToken")
+	config.DiscordFile = os.Getenv("ProxyDiscordFile")
+	if config.DiscordToken != "" && config.DiscordFile == "" {
+		println("ProxyDiscordFile is needed for the Discord bot, aborting...")
+		os.Exit(1)
+	}
+	prewarmLead, err := strconv.Atoi(os.Getenv("ProxyPrewarmLead"))
+	if err != nil {
+		prewarmLead = 5
+	}
+	config.PrewarmLead = time.Duration(prewarmLead) * time.Minute
+	prewarmGrace, err := strconv.Atoi(os.Getenv("ProxyPrewarmGrace"))
+	if err != nil {
+		prewarmGrace = 15
+	}
+	config.PrewarmGrace = time.Duration(prewarmGrace) * time.Minute
+	config.PrewarmProb, err = strconv.ParseFloat(os.Getenv("ProxyPrewarmThreshold"), 64)
+	if err != nil {
+		config.PrewarmProb = 0.5
+	}
+	config.RunningCaps, err = crafty.ParseRunningCaps(os.Getenv("ProxyRunningCaps"))
+	if err != nil {
+		println("Invalid ProxyRunningCaps: " + err.Error() + ", aborting...")
+		os.Exit(1)
+	}
+	config.MaxHostCpu, err = strconv.ParseFloat(os.Getenv("ProxyMaxHostCpu"), 64)
+	if err != nil {
+		config.MaxHostCpu = 0
+	}
+	config.MaxHostMem, err = strconv.ParseFloat(os.Getenv("ProxyMaxHostMemory"), 64)
+	if err != nil {
+		config.MaxHostMem = 0
+	}
+	admissionWait, err := strconv.Atoi(os.Getenv("ProxyAdmissionWait"))
+	if err != nil {
+		admissionWait = 0
+	}
+	config.AdmitWait = time.Duration(admissionWait) * time.Minute
 	return *config
 }
+
+// splitList splits a comma separated list, dropping empty entries
+func splitList(str string) []string {
+	var list []string
+	for _, item := range strings.Split(str, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
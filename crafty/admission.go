@@ -0,0 +1,135 @@
+package crafty
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const admissionRetryInterval = 15 * time.Second
+
+type hostStats struct {
+	CpuUsage   float64 `json:"cpu_usage"`
+	MemPercent float64 `json:"mem_percent"`
+	MemTotal   string  `json:"mem_total"`
+}
+
+// hostStats returns the live CPU and memory use of the Crafty host
+func (c *Crafty) hostStats() (*hostStats, error) {
+	get, err := c.Get("/api/v2/crafty/stats")
+	if err != nil {
+		return nil, errors.New("Can't get host stats: " + err.Error())
+	}
+	defer get.Body.Close()
+	body, err := io.ReadAll(get.Body)
+	if err != nil {
+		return nil, errors.New("Can't read response body: " + err.Error())
+	}
+	var response struct {
+		Data *hostStats `json:"data"`
+	}
+	err = json.Unmarshal(body, &response)
+	if err != nil || response.Data == nil {
+		return nil, errors.New("Can't get host stats: " + string(body))
+	}
+	return response.Data, nil
+}
+
+// Memory returns the heap the server is configured with, from the memory option
+// or the -Xmx flag of its Crafty execution command, 0 if it isn't known
+func (s *Server) Memory() int64 {
+	if s.memory != 0 {
+		return s.memory
+	}
+	get, err := s.parent.Get("/api/v2/servers/" + s.id)
+	if err != nil {
+		s.Logger.Println("Can't get server config: " + err.Error())
+		return 0
+	}
+	defer get.Body.Close()
+	var response struct {
+		Data struct {
+			Command string `json:"execution_command"`
+		} `json:"data"`
+	}
+	err = json.NewDecoder(get.Body).Decode(&response)
+	if err != nil {
+		s.Logger.Println("Can't extract JSON to object: " + err.Error())
+		return 0
+	}
+	for _, arg := range strings.Fields(response.Data.Command) {
+		if strings.HasPrefix(arg, "-Xmx") {
+			memory, err := ParseBytes(strings.TrimPrefix(arg, "-Xmx"))
+			if err == nil {
+				return memory
+			}
+		}
+	}
+	return 0
+}
+
+// admit checks whether the host has the CPU and memory headroom to start the server.
+// The host stats being unavailable doesn't block starts.
+func (s *Server) admit(name string) error {
+	c := s.parent
+	if c.MaxHostCpu <= 0 && c.MaxHostMemory <= 0 {
+		return nil
+	}
+	stats, err := c.hostStats()
+	if err != nil {
+		s.Logger.Println("Admitting start for " + name + " without host stats: " + err.Error())
+		return nil
+	}
+	memory := s.Memory()
+	projected := stats.MemPercent
+	total, err := ParseBytes(stats.MemTotal)
+	if err == nil && total > 0 {
+		projected += float64(memory) / float64(total) * 100
+	}
+	numbers := "CPU " + formatPercent(stats.CpuUsage) + " (max " + formatPercent(c.MaxHostCpu) + "), memory " +
+		formatPercent(stats.MemPercent) + " + " + FormatBytes(memory) + " = " + formatPercent(projected) +
+		" (max " + formatPercent(c.MaxHostMemory) + ")"
+	var reason string
+	if c.MaxHostCpu > 0 && stats.CpuUsage > c.MaxHostCpu {
+		reason = "host busy, CPU at " + formatPercent(stats.CpuUsage)
+	} else if c.MaxHostMemory > 0 && projected > c.MaxHostMemory {
+		reason = "host busy, memory would reach " + formatPercent(projected)
+	}
+	if reason == "" {
+		s.Logger.Println("Start for " + name + " admitted: " + numbers)
+		return nil
+	}
+	s.Logger.Println("Start for " + name + " denied: " + numbers)
+	if c.AdmissionWait > 0 && s.admitQueued.CompareAndSwap(false, true) {
+		go s.awaitAdmission(name)
+	}
+	if s.admitQueued.Load() {
+		reason += ", the start is queued"
+	}
+	return &StartError{Reason: reason}
+}
+
+// awaitAdmission retries a denied start until the host has room or the admission wait passes
+func (s *Server) awaitAdmission(name string) {
+	defer s.admitQueued.Store(false)
+	deadline := time.Now().Add(s.parent.AdmissionWait)
+	for time.Now().Before(deadline) {
+		time.Sleep(admissionRetryInterval)
+		if s.State != "stopped" && s.State != "crashed" {
+			return
+		}
+		var startErr *StartError
+		err := s.Start(name)
+		if !errors.As(err, &startErr) || !strings.HasPrefix(startErr.Reason, "host busy") {
+			return
+		}
+	}
+	s.Logger.Println("Queued start for " + name + " gave up after " + s.parent.AdmissionWait.String())
+}
+
+func formatPercent(value float64) string {
+	return strconv.FormatFloat(value, 'f', 1, 64) + "%"
+}
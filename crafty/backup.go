@@ -0,0 +1,122 @@
+package crafty
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	backupPollInterval = 5 * time.Second
+	backupTimeout      = 30 * time.Minute
+)
+
+type backupsResponse struct {
+	Data []jsonBackup `json:"data"`
+}
+
+type jsonBackup struct {
+	Id      string          `json:"backup_id"`
+	Default bool            `json:"default"`
+	Status  json.RawMessage `json:"status"`
+}
+
+// backupStatus is the status Crafty keeps per backup config, sometimes encoded as a string
+type backupStatus struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// autoStop is run by the stop timer, backing the server up first if it has the backup option
+func (s *Server) autoStop() {
+	s.parent.emit(Event{Type: EventIdleStop, Server: s.Name, Message: "stopping the idle server"})
+	if s.Backup {
+		err := s.backup()
+		if err != nil {
+			s.Logger.Println("Backup failed: " + err.Error())
+			if s.BackupRequired {
+				s.Logger.Println("Not stopping server, as it requires a backup")
+				return
+			}
+		}
+	}
+	_ = s.Stop()
+}
+
+// backup runs a Crafty backup and waits for it to finish, while wakes are blocked
+func (s *Server) backup() error {
+	s.backingUp.Store(true)
+	defer s.backingUp.Store(false)
+	previous := s.State
+	s.State = "backing_up"
+	defer func() {
+		s.State = previous
+	}()
+
+	action := "backup_server"
+	if s.BackupId != "" {
+		action += "/" + s.BackupId
+	}
+	s.Logger.Println("Backing up server before stopping it")
+	started := time.Now()
+	err := s.action(action)
+	if err != nil {
+		return err
+	}
+	// give Crafty time to pick the backup up before looking at its status
+	time.Sleep(backupPollInterval)
+	for time.Since(started) < backupTimeout {
+		status, err := s.backupStatus()
+		if err != nil {
+			return err
+		}
+		switch strings.ToLower(status.Status) {
+		case "":
+			s.Logger.Println("Crafty doesn't report backup progress, assuming the backup is done")
+			return nil
+		case "failed":
+			return errors.New(status.Message)
+		case "standby":
+			s.Logger.Println("Backup finished in " + time.Since(started).Round(time.Second).String())
+			return nil
+		}
+		time.Sleep(backupPollInterval)
+	}
+	return errors.New("backup didn't finish in " + backupTimeout.String())
+}
+
+// backupStatus returns the status of the configured backup, or the default one
+func (s *Server) backupStatus() (backupStatus, error) {
+	var status backupStatus
+	get, err := s.parent.Get("/api/v2/servers/" + s.id + "/backups")
+	if err != nil {
+		return status, err
+	}
+	defer get.Body.Close()
+	if get.StatusCode != 200 {
+		return status, errors.New("Can't get backups: " + get.Status)
+	}
+	body, err := io.ReadAll(get.Body)
+	if err != nil {
+		return status, err
+	}
+	var backups backupsResponse
+	err = json.Unmarshal(body, &backups)
+	if err != nil {
+		return status, err
+	}
+	for _, backup := range backups.Data {
+		if (s.BackupId != "" && backup.Id == s.BackupId) || (s.BackupId == "" && (backup.Default || len(backups.Data) == 1)) {
+			raw := backup.Status
+			var encoded string
+			if json.Unmarshal(raw, &encoded) == nil {
+				raw = []byte(encoded)
+			}
+			_ = json.Unmarshal(raw, &status)
+			return status, nil
+		}
+	}
+	return status, nil
+}
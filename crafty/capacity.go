@@ -0,0 +1,129 @@
+package crafty
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// RunningCap limits how many servers may run at once on the Crafty host when a server
+// of at least Priority wakes. With Evict, the least recently used empty server of the
+// same or lower priority is stopped to make room, otherwise the wake is refused.
+type RunningCap struct {
+	Priority int
+	Max      int
+	Evict    bool
+}
+
+// ParseRunningCaps parses a comma separated list of [priority:]max[:evict|refuse] entries,
+// the priority defaulting to 0 and the mode to refuse
+func ParseRunningCaps(str string) ([]RunningCap, error) {
+	var caps []RunningCap
+	for _, entry := range strings.Split(str, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		limit := RunningCap{}
+		switch parts[len(parts)-1] {
+		case "evict":
+			limit.Evict = true
+			parts = parts[:len(parts)-1]
+		case "refuse":
+			parts = parts[:len(parts)-1]
+		}
+		if len(parts) == 0 || len(parts) > 2 {
+			return nil, errors.New("invalid running cap \"" + entry + "\"")
+		}
+		var err error
+		if len(parts) == 2 {
+			limit.Priority, err = strconv.Atoi(parts[0])
+			if err != nil {
+				return nil, errors.New("invalid priority in running cap \"" + entry + "\"")
+			}
+		}
+		limit.Max, err = strconv.Atoi(parts[len(parts)-1])
+		if err != nil || limit.Max <= 0 {
+			return nil, errors.New("invalid maximum in running cap \"" + entry + "\"")
+		}
+		caps = append(caps, limit)
+	}
+	return caps, nil
+}
+
+// runningCap returns the cap for a priority, the one with the highest priority not above it
+func (c *Crafty) runningCap(priority int) *RunningCap {
+	var found *RunningCap
+	for i, limit := range c.RunningCaps {
+		if limit.Priority <= priority && (found == nil || limit.Priority > found.Priority) {
+			found = &c.RunningCaps[i]
+		}
+	}
+	return found
+}
+
+// occupiesSlot reports whether the server counts against the running cap
+func (s *Server) occupiesSlot() bool {
+	return s.State != "stopped" && s.State != "crashed" && s.State != "removed"
+}
+
+// evictable reports whether the server may be stopped to make room for one of the priority
+func (s *Server) evictable(priority int) bool {
+	return s.State == "running" && s.Priority <= priority && !s.stopping.Load() && !s.evicted.Load() &&
+		(s.players == 0 || s.allAfk()) && !s.inAlwaysOn()
+}
+
+// errMakingRoom is returned by makeRoom when the server starts once an evicted server stopped
+var errMakingRoom = errors.New("making room")
+
+// makeRoom checks the running cap before the server starts. If the cap allows it,
+// the least recently used empty server is stopped in the background and the server
+// starts after it. The caller holds the parent's capMu.
+func (s *Server) makeRoom(name string) error {
+	limit := s.parent.runningCap(s.Priority)
+	if limit == nil {
+		return nil
+	}
+	if s.evicting.Load() {
+		return errMakingRoom
+	}
+	running := filter(s.parent.Servers, func(other *Server) bool {
+		return other != s && other.occupiesSlot()
+	})
+	if len(running) < limit.Max {
+		return nil
+	}
+	candidates := filter(running, func(other *Server) bool {
+		return other.evictable(s.Priority)
+	})
+	if !limit.Evict || len(candidates) == 0 {
+		s.Logger.Println("Not starting server for " + name + ", " + strconv.Itoa(len(running)) + " servers are running")
+		return &StartError{Reason: "host busy, " + strconv.Itoa(len(running)) + " servers running"}
+	}
+	victim := slices.MinFunc(candidates, func(a, b *Server) int {
+		return a.lastUsed.Compare(b.lastUsed)
+	})
+	s.Logger.Println("Stopping " + victim.Name + ", the least recently used server, to make room for " + name)
+	s.evicting.Store(true)
+	victim.evicted.Store(true)
+	go s.evict(victim, name)
+	return errMakingRoom
+}
+
+// evict stops the victim, then starts the server in the room it made
+func (s *Server) evict(victim *Server, name string) {
+	victim.stopTimer.Stop()
+	err := victim.Stop()
+	victim.evicted.Store(false)
+	s.evicting.Store(false)
+	if err != nil {
+		s.Logger.Println("Not starting server for " + name + ", can't stop " + victim.Name + ": " + err.Error())
+		return
+	}
+	err = s.Start(name)
+	if err != nil {
+		s.Logger.Println(err.Error())
+	}
+}
@@ -10,21 +10,47 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/Botond24/CraftyProxy/policy"
 	"github.com/gorilla/websocket"
 )
 
 type Crafty struct {
-	ip          string
-	url         string
-	Key         string
-	Servers     []Server
-	logger      *log.Logger
-	StopTimeout time.Duration
+	ip             string
+	url            string
+	Key            string
+	Servers        []*Server
+	logger         *log.Logger
+	client         *http.Client
+	StopTimeout    time.Duration
+	WakeGrace      time.Duration
+	AfkTimeout     time.Duration
+	AfkBytes       int64
+	StopWarnings   []time.Duration
+	KillTimeout    time.Duration
+	StartupTimeout time.Duration
+	DataDir        string
+	Admins         []string
+	RunningCaps    []RunningCap
+	MaxHostCpu     float64
+	MaxHostMemory  float64
+	AdmissionWait  time.Duration
+	PrewarmLead    time.Duration
+	PrewarmGrace   time.Duration
+	PrewarmChance  float64
+	schedules      map[string]*Schedule
+	groups         []*Group
+	policyGroups   map[string][]string
+	policyDefault  map[string]*policy.Program
+	policies       map[string]map[string]*policy.Program
+	Playtime       *Playtime
+	capMu          sync.Mutex
+	subscribers    subscribers
 }
 
 type serversResponse struct {
@@ -47,51 +73,59 @@ func New(address string, port int, key string, timeout int) *Crafty {
 	c := new(Crafty)
 	c.url = "https://" + address + ":" + strconv.Itoa(port)
 	c.Key = key
-	c.Servers = []Server{}
+	c.Servers = []*Server{}
 	c.logger = log.New(os.Stdout, "crafty("+address+"): ", log.Ldate|log.Ltime)
+	// Crafty serves a self-signed certificate, so only its client skips verification
+	c.client = &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
 	c.StopTimeout = time.Duration(timeout)
+	c.WakeGrace = c.StopTimeout * time.Minute
+	c.AfkBytes = DefaultAfkBytes
+	c.KillTimeout = time.Minute
+	c.StartupTimeout = 5 * time.Minute
+	c.PrewarmLead = 5 * time.Minute
+	c.PrewarmGrace = 15 * time.Minute
+	c.PrewarmChance = 0.5
 	c.ip = address
 	return c
 }
 
 func (c *Crafty) Get(path string) (*http.Response, error) {
-	client := &http.Client{}
 	req, err := http.NewRequest("GET", c.url+path, nil)
 	if err != nil {
 		c.logger.Println("Can't create request: " + err.Error() + "\n")
 	}
 	req.Header.Set("Authorization", "Bearer "+c.Key)
-	return client.Do(req)
+	return c.client.Do(req)
 }
 
 func (c *Crafty) Post(path string, data []byte) (*http.Response, error) {
-	client := &http.Client{}
 	req, err := http.NewRequest("POST", c.url+path, strings.NewReader(string(data)))
 	if err != nil {
 		c.logger.Println("Can't create request: " + err.Error() + "\n")
 	}
 	req.Header.Set("Authorization", "Bearer "+c.Key)
-	return client.Do(req)
+	return c.client.Do(req)
 }
 
 func (c *Crafty) Patch(path string, data []byte) (*http.Response, error) {
-	client := &http.Client{}
 	req, err := http.NewRequest("PATCH", c.url+path, strings.NewReader(string(data)))
 	if err != nil {
 		c.logger.Println("Can't create request: " + err.Error() + "\n")
 	}
 	req.Header.Set("Authorization", "Bearer "+c.Key)
-	return client.Do(req)
+	return c.client.Do(req)
 }
 
 func (c *Crafty) Put(path string, data []byte) (*http.Response, error) {
-	client := &http.Client{}
 	req, err := http.NewRequest("PUT", c.url+path, strings.NewReader(string(data)))
 	if err != nil {
 		c.logger.Println("Can't create request: " + err.Error() + "\n")
 	}
 	req.Header.Set("Authorization", "Bearer "+c.Key)
-	return client.Do(req)
+	return c.client.Do(req)
 }
 
 func (c *Crafty) GetServers() {
@@ -111,34 +145,53 @@ func (c *Crafty) GetServers() {
 	if err != nil {
 		panic("Can't extract JSON to object: " + err.Error() + "\n")
 	}
+	var added []*Server
 	for _, server := range servers.Data {
+		if slices.ContainsFunc(c.Servers, func(known *Server) bool { return known.id == server.Id }) {
+			continue
+		}
 		s := NewServer(c, server)
-		c.Servers = append(c.Servers, *s)
+		c.Servers = append(c.Servers, s)
+		if s.AutoOn || s.AutoOff {
+			go s.watch()
+			added = append(added, s)
+		}
+		if s.Prewarm && s.AutoOff {
+			go s.prewarm()
+		}
 	}
-	c.Servers = filter(c.Servers, func(server Server) bool {
+	c.Servers = filter(c.Servers, func(server *Server) bool {
 		return server.AutoOn || server.AutoOff
 	})
+	// servers Crafty adds later get the groups, policies and schedules loaded at startup
+	for _, s := range added {
+		c.applyGroup(s)
+		c.applyPolicies(s)
+		c.applySchedule(s)
+	}
 	c.logger.Println("Found " + strconv.Itoa(len(c.Servers)) + " servers")
 }
 
 func (c *Crafty) ListenWs(wg *sync.WaitGroup, cb func(*Server, string)) {
 	wsUrl := strings.ReplaceAll(c.url, "https://", "wss://") + "/ws"
-	websocket.DefaultDialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: websocket.DefaultDialer.HandshakeTimeout,
+		TLSClientConfig:  &tls.Config{InsecureSkipVerify: true},
+	}
 	u, err := url.Parse(wsUrl)
 	if err != nil {
 		c.logger.Println("Can't parse ws url: " + err.Error() + "\n")
 		return
 	}
-	if websocket.DefaultDialer.Jar == nil {
-		websocket.DefaultDialer.Jar, err = cookiejar.New(nil)
-		if err != nil {
-			c.logger.Println("Can't create cookie jar: " + err.Error() + "\n")
-			return
-		}
+	dialer.Jar, err = cookiejar.New(nil)
+	if err != nil {
+		c.logger.Println("Can't create cookie jar: " + err.Error() + "\n")
+		return
 	}
-	websocket.DefaultDialer.Jar.SetCookies(u, []*http.Cookie{
+	dialer.Jar.SetCookies(u, []*http.Cookie{
 		{Name: "token", Value: c.Key}})
-	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	conn, _, err := dialer.Dial(u.String(), nil)
 	if err != nil {
 		panic("Can't connect to ws: " + err.Error() + "\n")
 	}
@@ -152,19 +205,19 @@ func (c *Crafty) ListenWs(wg *sync.WaitGroup, cb func(*Server, string)) {
 		for {
 			_, message, err := conn.ReadMessage()
 			if err != nil {
-
+				c.emit(Event{Type: EventDisconnected, Message: "Crafty websocket disconnected: " + err.Error()})
 				return
 			}
 			log.Printf("recv: %s", message)
 			var wsMessage wsResponse
 			err = json.Unmarshal(message, &wsMessage)
 			if err != nil {
-				log.Fatalf(err.Error())
+				log.Fatal(err)
 				return
 			}
 			if wsMessage.Event == "update" {
 				c.GetServers()
-				servers := filter(c.Servers, func(server Server) bool {
+				servers := filter(c.Servers, func(server *Server) bool {
 					return !server.Handled
 				})
 				c.logger.Println("Found " + strconv.Itoa(len(servers)) + " new servers")
@@ -172,7 +225,7 @@ func (c *Crafty) ListenWs(wg *sync.WaitGroup, cb func(*Server, string)) {
 					wg.Add(1)
 					go func() {
 						defer wg.Done()
-						cb(&server, c.ip)
+						cb(server, c.ip)
 					}()
 				}
 			}
@@ -199,6 +252,43 @@ func (c *Crafty) ListenWs(wg *sync.WaitGroup, cb func(*Server, string)) {
 	}
 }
 
+// Server returns the server with the given name, nil if there's none
+func (c *Crafty) Server(name string) *Server {
+	for _, s := range c.Servers {
+		if strings.EqualFold(s.Name, name) {
+			return s
+		}
+	}
+	return nil
+}
+
+// IsAdmin reports whether a player is one of the proxy admins
+func (c *Crafty) IsAdmin(name string) bool {
+	return slices.ContainsFunc(c.Admins, func(admin string) bool {
+		return strings.EqualFold(admin, name)
+	})
+}
+
+// LoadMaintenance reads the maintenance file, which lists one server name per line.
+// Listed servers are put into maintenance, the others return to the mode from their options.
+func (c *Crafty) LoadMaintenance(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		c.logger.Println("Can't read maintenance file: " + err.Error())
+		return
+	}
+	listed := map[string]bool{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			listed[strings.ToLower(line)] = true
+		}
+	}
+	for _, s := range c.Servers {
+		s.SetMaintenance(s.maintOption || listed[strings.ToLower(s.Name)], "maintenance file")
+	}
+}
+
 func filter[T any](s []T, predicate func(T) bool) []T {
 	result := make([]T, 0, len(s)) // Pre-allocate for efficiency
 	for _, v := range s {
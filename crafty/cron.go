@@ -0,0 +1,96 @@
+package crafty
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronExpr is a standard 5 field cron expression: minute, hour, day of month, month, day of week
+type cronExpr struct {
+	minute, hour, dom, month, dow [64]bool
+	// domAny and dowAny are set for *, as cron matches either day field if both are restricted
+	domAny, dowAny bool
+}
+
+func parseCron(expr string) (*cronExpr, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.New("cron expression \"" + expr + "\" must have 5 fields")
+	}
+	c := &cronExpr{}
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	if c.dow[7] {
+		c.dow[0] = true
+	}
+	c.domAny = fields[2] == "*"
+	c.dowAny = fields[4] == "*"
+	return c, nil
+}
+
+// parseCronField parses lists of values, ranges and steps like 1,5-10,*/15
+func parseCronField(field string, min int, max int) (set [64]bool, err error) {
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step <= 0 {
+				return set, errors.New("invalid step in cron field \"" + field + "\"")
+			}
+		}
+		low, high := min, max
+		if rangePart != "*" {
+			lowPart, highPart, isRange := strings.Cut(rangePart, "-")
+			low, err = strconv.Atoi(lowPart)
+			if err != nil {
+				return set, errors.New("invalid cron field \"" + field + "\"")
+			}
+			high = low
+			if isRange {
+				high, err = strconv.Atoi(highPart)
+				if err != nil {
+					return set, errors.New("invalid cron field \"" + field + "\"")
+				}
+			} else if hasStep {
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return set, errors.New("cron field \"" + field + "\" is out of range")
+		}
+		for i := low; i <= high; i += step {
+			set[i] = true
+		}
+	}
+	return set, nil
+}
+
+// matches reports whether the expression fires in the minute of t
+func (c *cronExpr) matches(t time.Time) bool {
+	if !c.minute[t.Minute()] || !c.hour[t.Hour()] || !c.month[int(t.Month())] {
+		return false
+	}
+	dom := c.dom[t.Day()]
+	dow := c.dow[int(t.Weekday())]
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
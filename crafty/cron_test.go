@@ -0,0 +1,116 @@
+package crafty
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field string
+		min   int
+		max   int
+		want  []int
+	}{
+		{"*", 0, 5, []int{0, 1, 2, 3, 4, 5}},
+		{"3", 0, 59, []int{3}},
+		{"1-4", 0, 59, []int{1, 2, 3, 4}},
+		{"1,5,9", 0, 59, []int{1, 5, 9}},
+		{"*/15", 0, 59, []int{0, 15, 30, 45}},
+		{"10-20/5", 0, 59, []int{10, 15, 20}},
+		{"50/3", 0, 59, []int{50, 53, 56, 59}},
+		{"1-3,10-30/10,59", 0, 59, []int{1, 2, 3, 10, 20, 30, 59}},
+		{"*/2", 1, 7, []int{1, 3, 5, 7}},
+	}
+	for _, test := range tests {
+		set, err := parseCronField(test.field, test.min, test.max)
+		if err != nil {
+			t.Errorf("parseCronField(%q) failed: %v", test.field, err)
+			continue
+		}
+		var got []int
+		for i, ok := range set {
+			if ok {
+				got = append(got, i)
+			}
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("parseCronField(%q) = %v, want %v", test.field, got, test.want)
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	tests := []string{
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"1-b * * * *",
+	}
+	for _, expr := range tests {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestCronMatches(t *testing.T) {
+	// 2026-10-19 is a Monday
+	at := func(month time.Month, day int, hour int, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		expr string
+		t    time.Time
+		want bool
+	}{
+		{"* * * * *", at(10, 19, 3, 7), true},
+		{"30 18 * * *", at(10, 19, 18, 30), true},
+		{"30 18 * * *", at(10, 19, 18, 31), false},
+		{"*/15 * * * *", at(10, 19, 9, 45), true},
+		{"*/15 * * * *", at(10, 19, 9, 46), false},
+		{"0 9-17 * * *", at(10, 19, 17, 0), true},
+		{"0 9-17 * * *", at(10, 19, 18, 0), false},
+		{"0 8,20 * * *", at(10, 19, 20, 0), true},
+		{"0 8,20 * * *", at(10, 19, 12, 0), false},
+		{"0 0 * 10 *", at(10, 1, 0, 0), true},
+		{"0 0 * 10 *", at(11, 1, 0, 0), false},
+		// Only the day of week is restricted
+		{"0 0 * * 1", at(10, 19, 0, 0), true},
+		{"0 0 * * 1", at(10, 20, 0, 0), false},
+		{"0 0 * * 1-5", at(10, 23, 0, 0), true},
+		{"0 0 * * 1-5", at(10, 24, 0, 0), false},
+		// Sunday is both 0 and 7
+		{"0 0 * * 7", at(10, 25, 0, 0), true},
+		{"0 0 * * 0", at(10, 25, 0, 0), true},
+		// Only the day of month is restricted
+		{"0 0 1 * *", at(10, 1, 0, 0), true},
+		{"0 0 1 * *", at(10, 19, 0, 0), false},
+		// Both are restricted, so either one matches
+		{"0 0 1 * 1", at(10, 1, 0, 0), true},
+		{"0 0 1 * 1", at(10, 19, 0, 0), true},
+		{"0 0 1 * 1", at(10, 20, 0, 0), false},
+		{"0 0 13 * 5", at(11, 13, 0, 0), true},
+		{"0 0 13 * 5", at(11, 6, 0, 0), true},
+		{"0 0 13 * 5", at(11, 7, 0, 0), false},
+	}
+	for _, test := range tests {
+		c, err := parseCron(test.expr)
+		if err != nil {
+			t.Errorf("parseCron(%q) failed: %v", test.expr, err)
+			continue
+		}
+		if got := c.matches(test.t); got != test.want {
+			t.Errorf("%q matches %v = %v, want %v", test.expr, test.t.Format("Mon Jan 2 15:04"), got, test.want)
+		}
+	}
+}
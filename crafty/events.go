@@ -0,0 +1,62 @@
+package crafty
+
+import (
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventWaking         EventType = "waking"
+	EventReady          EventType = "ready"
+	EventStopped        EventType = "stopped"
+	EventCrashed        EventType = "crashed"
+	EventStartFailed    EventType = "start_failed"
+	EventStartEscalated EventType = "start_escalated"
+	EventPlayerJoined   EventType = "player_joined"
+	EventPlayerLeft     EventType = "player_left"
+	EventIdleStop       EventType = "idle_stop"
+	EventQuotaReached   EventType = "quota_reached"
+	// EventDisconnected is Crafty's websocket closing, it has no server
+	EventDisconnected EventType = "ws_disconnected"
+)
+
+// EventTypes is every type of event, in lifecycle order
+var EventTypes = []EventType{
+	EventWaking, EventReady, EventStopped, EventCrashed, EventStartFailed, EventStartEscalated, EventPlayerJoined,
+	EventPlayerLeft, EventIdleStop, EventQuotaReached, EventDisconnected,
+}
+
+// Event is something that happened to a server, passed to the subscribers
+type Event struct {
+	Type    EventType `json:"type"`
+	Server  string    `json:"server"`
+	Player  string    `json:"player,omitempty"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+type subscribers struct {
+	mu  sync.Mutex
+	fns []func(Event)
+}
+
+// Subscribe registers a function called for every event, in its own goroutine
+func (c *Crafty) Subscribe(fn func(Event)) {
+	c.subscribers.mu.Lock()
+	defer c.subscribers.mu.Unlock()
+	c.subscribers.fns = append(c.subscribers.fns, fn)
+}
+
+func (c *Crafty) emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	c.logger.Println("Event " + string(e.Type) + " for " + e.Server + ": " + e.Message)
+	c.subscribers.mu.Lock()
+	defer c.subscribers.mu.Unlock()
+	for _, fn := range c.subscribers.fns {
+		go fn(e)
+	}
+}
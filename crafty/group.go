@@ -0,0 +1,217 @@
+package crafty
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const readyPollInterval = 2 * time.Second
+
+// Group is servers that depend on each other, every member depending on the ones before it.
+// Members start in order and the group stops in reverse order once all of them are idle.
+type Group struct {
+	Name    string
+	Members []*Server
+	names   []string
+	stopMu  sync.Mutex
+}
+
+// LoadGroups reads the groups file, which maps group names to their members in start order.
+// Members that don't exist yet join their group once Crafty adds them.
+func (c *Crafty) LoadGroups(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var groups map[string][]string
+	err = json.Unmarshal(data, &groups)
+	if err != nil {
+		return err
+	}
+	seen := map[string]string{}
+	for name, members := range groups {
+		for _, member := range members {
+			if other, ok := seen[strings.ToLower(member)]; ok {
+				return errors.New("group " + name + ": " + member + " is already in group " + other)
+			}
+			seen[strings.ToLower(member)] = name
+			servers := c.serversNamed(member)
+			if len(servers) > 1 {
+				return errors.New("group " + name + ": several servers are called " + member)
+			}
+			if len(servers) == 0 {
+				c.logger.Println("Group " + name + ": unknown server " + member + " waits for it to be added")
+			}
+		}
+		c.groups = append(c.groups, &Group{Name: name, names: members})
+	}
+	for _, s := range c.Servers {
+		c.applyGroup(s)
+	}
+	for _, group := range c.groups {
+		c.logger.Println("Loaded group " + group.Name + " of " + formatNames(group.Members))
+	}
+	return nil
+}
+
+// applyGroup puts the server in its group, if it has one, keeping the members in start order
+func (c *Crafty) applyGroup(s *Server) {
+	for _, group := range c.groups {
+		if !slices.ContainsFunc(group.names, func(name string) bool {
+			return strings.EqualFold(name, s.Name)
+		}) {
+			continue
+		}
+		var members []*Server
+		for _, name := range group.names {
+			servers := c.serversNamed(name)
+			if len(servers) > 1 {
+				c.logger.Println("Group " + group.Name + ": several servers are called " + name + ", leaving them out")
+				continue
+			}
+			members = append(members, servers...)
+		}
+		group.Members = members
+		if slices.Contains(members, s) {
+			s.group = group
+		}
+	}
+}
+
+// serversNamed returns the servers with the name, ignoring case like Server does
+func (c *Crafty) serversNamed(name string) []*Server {
+	return filter(c.Servers, func(s *Server) bool {
+		return strings.EqualFold(s.Name, name)
+	})
+}
+
+// dependencies returns the members the server depends on, in start order
+func (s *Server) dependencies() []*Server {
+	if s.group == nil {
+		return nil
+	}
+	i := slices.Index(s.group.Members, s)
+	if i == -1 {
+		return nil
+	}
+	return s.group.Members[:i]
+}
+
+// ready reports whether the server is up and answering pings
+func (s *Server) ready() bool {
+	return s.State == "running" || (s.State == "starting" && s.checkPing())
+}
+
+// dependenciesReady reports whether every dependency of the server is ready
+func (s *Server) dependenciesReady() bool {
+	return !slices.ContainsFunc(s.dependencies(), func(dependency *Server) bool {
+		return !dependency.ready()
+	})
+}
+
+// startWithDependencies starts the dependencies one by one, waiting for each to be ready,
+// then the server itself
+func (s *Server) startWithDependencies(name string) {
+	defer s.groupStarting.Store(false)
+	for _, dependency := range s.dependencies() {
+		if dependency.ready() {
+			continue
+		}
+		s.Logger.Println("Starting dependency " + dependency.Name + " for " + name)
+		err := dependency.Start(name)
+		if err != nil {
+			s.Logger.Println("Can't start dependency " + dependency.Name + ": " + err.Error())
+			return
+		}
+		if !dependency.waitReady(s.parent.StartupTimeout) {
+			s.Logger.Println("Dependency " + dependency.Name + " isn't ready after " + s.parent.StartupTimeout.String())
+			return
+		}
+	}
+	err := s.Start(name)
+	if err != nil {
+		s.Logger.Println(err.Error())
+	}
+}
+
+// waitReady polls the server until it answers pings, or the timeout passes
+func (s *Server) waitReady(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if s.State == "running" {
+			return true
+		}
+		if s.checkPing() {
+			s.setState("running", "the server answers pings")
+			return true
+		}
+		time.Sleep(readyPollInterval)
+	}
+	return false
+}
+
+// idle reports whether every member of the group may be stopped
+func (g *Group) idle() bool {
+	return !slices.ContainsFunc(g.Members, func(member *Server) bool {
+		return (member.players > 0 && !member.allAfk()) || member.inAlwaysOn() || member.groupStarting.Load() ||
+			(member.occupiesSlot() && member.unproxiedPlayers())
+	})
+}
+
+// stop stops the running members with player-stop in reverse start order
+func (g *Group) stop() {
+	if !g.stopMu.TryLock() {
+		return
+	}
+	defer g.stopMu.Unlock()
+	for i := len(g.Members) - 1; i >= 0; i-- {
+		member := g.Members[i]
+		if !member.AutoOff || !member.occupiesSlot() {
+			continue
+		}
+		member.Logger.Println("Stopping server with its group " + g.Name)
+		member.stopTimer.Stop()
+		member.autoStop()
+	}
+}
+
+// idleStop is run by the stop timer, stopping the server's whole group once every member is idle
+func (s *Server) idleStop() {
+	if s.group == nil {
+		s.autoStop()
+		return
+	}
+	refused := ""
+	if !s.group.idle() {
+		refused = "other members of group " + s.group.Name + " are in use"
+	}
+	for _, member := range s.group.Members {
+		if refused == "" && member != s && member.AutoOff && member.occupiesSlot() &&
+			!member.Decide(DecisionStop, true, "", "", "") {
+			refused = "the stop policy of " + member.Name + " refused"
+		}
+	}
+	if refused != "" {
+		s.Logger.Println("Not stopping server, " + refused + ", checking again in " + strconv.Itoa(int(s.parent.StopTimeout)) + " minutes")
+		s.stopTimer.Reset(s.parent.StopTimeout * time.Minute)
+		return
+	}
+	s.group.stop()
+}
+
+func formatNames(servers []*Server) string {
+	names := ""
+	for i, s := range servers {
+		if i > 0 {
+			names += ", "
+		}
+		names += s.Name
+	}
+	return names
+}
@@ -0,0 +1,297 @@
+package crafty
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	playtimeInterval = 30 * time.Second
+	// keepPlaytimeDays covers the current and the previous week
+	keepPlaytimeDays = 14
+)
+
+// playtimeWarnings are the remaining times players are warned at
+var playtimeWarnings = []time.Duration{15 * time.Minute, 5 * time.Minute, time.Minute}
+
+// PlayLimit is a daily and weekly playtime quota, zero meaning no limit.
+// Weeks start on Monday.
+type PlayLimit struct {
+	Daily  string `json:"daily"`
+	Weekly string `json:"weekly"`
+
+	daily  time.Duration
+	weekly time.Duration
+}
+
+// PlayGroup applies a limit to every member
+type PlayGroup struct {
+	Members []string `json:"members"`
+	PlayLimit
+}
+
+// playtimeFile is the content of the playtime file, players being keyed by name or UUID.
+// A player's own limit takes precedence over the groups they're in, of which the
+// strictest daily and weekly limits apply.
+type playtimeFile struct {
+	Players map[string]*PlayLimit `json:"players"`
+	Groups  map[string]*PlayGroup `json:"groups"`
+}
+
+// Playtime tracks how long players play per day across all servers, to enforce their limits
+type Playtime struct {
+	mu     sync.Mutex
+	path   string
+	limits playtimeFile
+	// warned is the last warning sent to each player, until their playtime resets
+	warned map[string]playWarning
+	Days   map[string]map[string]time.Duration `json:"days"`
+}
+
+type playWarning struct {
+	left   time.Duration
+	resets time.Time
+}
+
+// LoadPlaytime reads the playtime limits and the stored playtime, and starts enforcing them
+func (c *Crafty) LoadPlaytime(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	p := &Playtime{warned: map[string]playWarning{}, Days: map[string]map[string]time.Duration{}}
+	err = json.Unmarshal(data, &p.limits)
+	if err != nil {
+		return err
+	}
+	for name, limit := range p.limits.Players {
+		err = limit.parse()
+		if err != nil {
+			return errors.New("playtime of " + name + ": " + err.Error())
+		}
+	}
+	for name, group := range p.limits.Groups {
+		err = group.parse()
+		if err != nil {
+			return errors.New("playtime of group " + name + ": " + err.Error())
+		}
+	}
+	if c.DataDir != "" {
+		p.path = filepath.Join(c.DataDir, "playtime.json")
+		stored, err := os.ReadFile(p.path)
+		if err == nil {
+			_ = json.Unmarshal(stored, p)
+		}
+	}
+	c.Playtime = p
+	go c.trackPlaytime()
+	return nil
+}
+
+func (limit *PlayLimit) parse() (err error) {
+	if limit.Daily != "" {
+		limit.daily, err = time.ParseDuration(limit.Daily)
+		if err != nil {
+			return
+		}
+	}
+	if limit.Weekly != "" {
+		limit.weekly, err = time.ParseDuration(limit.Weekly)
+	}
+	return
+}
+
+// limit returns the limit of a player, nil if they have none
+func (p *Playtime) limit(player string, id string) *PlayLimit {
+	for key, limit := range p.limits.Players {
+		if strings.EqualFold(key, player) || (id != "" && strings.EqualFold(key, id)) {
+			return limit
+		}
+	}
+	var strictest *PlayLimit
+	for _, group := range p.limits.Groups {
+		if !slices.ContainsFunc(group.Members, func(member string) bool {
+			return strings.EqualFold(member, player) || (id != "" && strings.EqualFold(member, id))
+		}) {
+			continue
+		}
+		if strictest == nil {
+			strictest = &PlayLimit{daily: group.daily, weekly: group.weekly}
+			continue
+		}
+		strictest.daily = stricter(strictest.daily, group.daily)
+		strictest.weekly = stricter(strictest.weekly, group.weekly)
+	}
+	return strictest
+}
+
+// stricter returns the smaller of two limits, zero being no limit
+func stricter(a time.Duration, b time.Duration) time.Duration {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// played returns how long the player played on the day of now and in its week, the caller holds the lock
+func (p *Playtime) played(player string, now time.Time) (day time.Duration, week time.Duration) {
+	days := p.Days[strings.ToLower(player)]
+	day = days[now.Format(dayFormat)]
+	monday := now.AddDate(0, 0, -(int(now.Weekday())+6)%7)
+	for d := monday; !d.After(now); d = d.AddDate(0, 0, 1) {
+		week += days[d.Format(dayFormat)]
+	}
+	return
+}
+
+// remaining returns the playtime left for the player and when it resets, ok being false
+// if the player has no limit
+func (p *Playtime) remaining(player string, id string, now time.Time) (left time.Duration, resets time.Time, ok bool) {
+	limit := p.limit(player, id)
+	if limit == nil || (limit.daily == 0 && limit.weekly == 0) {
+		return 0, time.Time{}, false
+	}
+	p.mu.Lock()
+	day, week := p.played(player, now)
+	p.mu.Unlock()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, 1)
+	left = -1
+	if limit.daily > 0 {
+		left, resets = limit.daily-day, midnight
+	}
+	if limit.weekly > 0 && (left < 0 || limit.weekly-week < left) {
+		left, resets = limit.weekly-week, midnight.AddDate(0, 0, (8-int(midnight.Weekday()))%7)
+	}
+	return max(left, 0), resets, true
+}
+
+// PlaytimeDenial returns why the player can't join because of their playtime limit,
+// empty if they may
+func (s *Server) PlaytimeDenial(player string, id string) string {
+	if s.parent.Playtime == nil {
+		return ""
+	}
+	left, resets, ok := s.parent.Playtime.remaining(player, id, time.Now())
+	if !ok || left > 0 {
+		return ""
+	}
+	return "You used up your playtime, it resets on " + resets.Format("Mon 2 Jan 15:04")
+}
+
+// add counts playtime for the player on the day of now, the caller holds the lock
+func (p *Playtime) add(player string, played time.Duration, now time.Time) {
+	key := strings.ToLower(player)
+	if p.Days[key] == nil {
+		p.Days[key] = map[string]time.Duration{}
+	}
+	p.Days[key][now.Format(dayFormat)] += played
+	oldest := now.AddDate(0, 0, -keepPlaytimeDays).Format(dayFormat)
+	for day := range p.Days[key] {
+		if day < oldest {
+			delete(p.Days[key], day)
+		}
+	}
+}
+
+func (p *Playtime) save() error {
+	if p.path == "" {
+		return nil
+	}
+	p.mu.Lock()
+	data, err := json.Marshal(p)
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p.path, data, 0o644)
+}
+
+// account adds the time played since the session was last accounted
+func (p *Playtime) account(session *Session, now time.Time) {
+	session.mu.Lock()
+	played := now.Sub(session.accounted)
+	session.accounted = now
+	session.mu.Unlock()
+	p.mu.Lock()
+	p.add(session.Player, played, now)
+	p.mu.Unlock()
+}
+
+// trackPlaytime counts the playtime of every open session, warning players as they
+// approach their limit and disconnecting them at it
+func (c *Crafty) trackPlaytime() {
+	for {
+		time.Sleep(playtimeInterval)
+		now := time.Now()
+		for _, s := range c.Servers {
+			for _, session := range s.Sessions() {
+				c.Playtime.account(session, now)
+				s.enforcePlaytime(session, now)
+			}
+		}
+		c.Playtime.mu.Lock()
+		for player, warned := range c.Playtime.warned {
+			if !now.Before(warned.resets) {
+				delete(c.Playtime.warned, player)
+			}
+		}
+		c.Playtime.mu.Unlock()
+		err := c.Playtime.save()
+		if err != nil {
+			c.logger.Println("Can't save playtime: " + err.Error())
+		}
+	}
+}
+
+func (s *Server) enforcePlaytime(session *Session, now time.Time) {
+	p := s.parent.Playtime
+	left, resets, ok := p.remaining(session.Player, session.UUID, now)
+	if !ok {
+		return
+	}
+	if left <= 0 {
+		s.Logger.Println("Disconnecting " + session.Player + ", their playtime is used up")
+		message := "Your playtime is used up, it resets on " + resets.Format("Mon 2 Jan 15:04")
+		err := s.SendCommand("kick " + session.Player + " " + message)
+		if err != nil {
+			s.Logger.Println("Can't kick " + session.Player + ": " + err.Error())
+			session.Disconnect()
+		}
+		return
+	}
+	// warn once per threshold, the smallest one that was reached
+	i := slices.IndexFunc(playtimeWarnings, func(warning time.Duration) bool {
+		return left > warning
+	})
+	if i == 0 {
+		return
+	}
+	if i == -1 {
+		i = len(playtimeWarnings)
+	}
+	warning := playtimeWarnings[i-1]
+	key := strings.ToLower(session.Player)
+	p.mu.Lock()
+	warned, found := p.warned[key]
+	if found && warned.resets.Equal(resets) && warned.left <= warning {
+		p.mu.Unlock()
+		return
+	}
+	p.warned[key] = playWarning{left: warning, resets: resets}
+	p.mu.Unlock()
+	s.Logger.Println("Warning " + session.Player + ", " + left.Round(time.Minute).String() + " of playtime left")
+	text, _ := json.Marshal(map[string]string{
+		"text":  "You have " + left.Round(time.Minute).String() + " of playtime left",
+		"color": "yellow",
+	})
+	err := s.SendCommand("tellraw " + session.Player + " " + string(text))
+	if err != nil {
+		s.Logger.Println("Can't warn " + session.Player + ": " + err.Error())
+	}
+}
@@ -0,0 +1,151 @@
+package crafty
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Botond24/CraftyProxy/policy"
+)
+
+// The decisions policies can make, each replacing a hard-wired default when set
+const (
+	// DecisionWake decides whether a join attempt starts a sleeping server, instead of player-start
+	DecisionWake = "wake"
+	// DecisionAdmit decides whether a player may join the running server
+	DecisionAdmit = "admit"
+	// DecisionStop decides whether an idle server with player-stop is stopped
+	DecisionStop = "stop"
+)
+
+// policyFile is the content of the policy file. Groups are lists of players usable by name
+// in expressions, and the default rules apply to servers without rules of their own.
+type policyFile struct {
+	Groups  map[string][]string          `json:"groups"`
+	Default map[string]string            `json:"default"`
+	Servers map[string]map[string]string `json:"servers"`
+}
+
+// LoadPolicies reads and compiles the policy file, failing on any invalid expression
+func (c *Crafty) LoadPolicies(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var file policyFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return err
+	}
+	sample := c.policyVars(nil, "", "", "")
+	for name := range file.Groups {
+		if _, ok := sample[name]; ok {
+			return errors.New("group " + name + " has the name of a built-in value")
+		}
+		sample[name] = file.Groups[name]
+	}
+	c.policyGroups = file.Groups
+	c.policyDefault, err = compileRules("default", file.Default, sample)
+	if err != nil {
+		return err
+	}
+	c.policies = map[string]map[string]*policy.Program{}
+	for name, rules := range file.Servers {
+		c.policies[name], err = compileRules(name, rules, sample)
+		if err != nil {
+			return err
+		}
+		if c.Server(name) == nil {
+			c.logger.Println("Policies for unknown server " + name + " wait for it to be added")
+		}
+	}
+	for _, s := range c.Servers {
+		c.applyPolicies(s)
+	}
+	return nil
+}
+
+// applyPolicies gives the server its own policies, or the default ones
+func (c *Crafty) applyPolicies(s *Server) {
+	s.policies = c.policyDefault
+	for name, programs := range c.policies {
+		if strings.EqualFold(name, s.Name) {
+			s.policies = programs
+		}
+	}
+}
+
+func compileRules(name string, rules map[string]string, sample policy.Vars) (map[string]*policy.Program, error) {
+	programs := map[string]*policy.Program{}
+	for decision, source := range rules {
+		if decision != DecisionWake && decision != DecisionAdmit && decision != DecisionStop {
+			return nil, errors.New("policies of " + name + ": unknown decision " + decision)
+		}
+		program, err := policy.Compile(source, sample)
+		if err != nil {
+			return nil, errors.New("policies of " + name + ", " + decision + ": " + err.Error())
+		}
+		programs[decision] = program
+	}
+	return programs, nil
+}
+
+// policyVars are the values expressions can use, s being nil when only their types matter
+func (c *Crafty) policyVars(s *Server, player string, id string, addr string) policy.Vars {
+	now := time.Now()
+	ip := addr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		ip = host
+	}
+	running := len(filter(c.Servers, func(other *Server) bool {
+		return other.occupiesSlot()
+	}))
+	vars := policy.Vars{
+		"player":      player,
+		"uuid":        id,
+		"ip":          ip,
+		"hour":        float64(now.Hour()),
+		"minute":      float64(now.Minute()),
+		"weekday":     strings.ToLower(now.Weekday().String()),
+		"running":     float64(running),
+		"admins":      append([]string{}, c.Admins...),
+		"server":      "",
+		"state":       "",
+		"players":     float64(0),
+		"max_players": float64(0),
+	}
+	if s != nil {
+		vars["server"] = s.Name
+		vars["state"] = s.State
+		vars["players"] = float64(s.players)
+		vars["max_players"] = float64(s.MaxPlayers())
+	}
+	for name, members := range c.policyGroups {
+		vars[name] = members
+	}
+	return vars
+}
+
+// Decide makes a decision with the server's policy, logging how it was made.
+// Without a policy for the decision, or if it can't be evaluated, fallback is the answer.
+func (s *Server) Decide(decision string, fallback bool, player string, id string, addr string) bool {
+	program, ok := s.policies[decision]
+	if !ok {
+		return fallback
+	}
+	vars := s.parent.policyVars(s, player, id, addr)
+	result, err := program.Eval(vars)
+	if err != nil {
+		s.Logger.Println("Policy " + decision + " failed, using the default: " + err.Error())
+		return fallback
+	}
+	subject := ""
+	if player != "" {
+		subject = " for " + player
+	}
+	s.Logger.Printf("Policy %s%s: %s is %t (%s)", decision, subject, program, result, program.Trace(vars))
+	return result
+}
@@ -0,0 +1,133 @@
+package crafty
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	prewarmInterval = time.Minute
+	week            = 7 * 24 * time.Hour
+	// historyWeeks is how many weeks of wakes and joins are kept for predictions
+	historyWeeks = 8
+)
+
+// History is the wakes and joins of a server over the last weeks, used to predict joins
+type History struct {
+	mu    sync.Mutex
+	path  string
+	Wakes []time.Time `json:"wakes"`
+	Joins []time.Time `json:"joins"`
+}
+
+// Prediction is the share of observed weeks that had a join in an hour of the week
+type Prediction struct {
+	Weekday     string  `json:"weekday"`
+	Hour        int     `json:"hour"`
+	Probability float64 `json:"probability"`
+}
+
+// loadHistory reads the history file from the data dir, or starts empty if there's none
+func loadHistory(dataDir string, id string) *History {
+	h := &History{}
+	if dataDir == "" {
+		return h
+	}
+	h.path = filepath.Join(dataDir, "history-"+id+".json")
+	data, err := os.ReadFile(h.path)
+	if err != nil {
+		return h
+	}
+	_ = json.Unmarshal(data, h)
+	return h
+}
+
+// record adds a timestamp to a list, dropping the ones older than the kept weeks, and saves
+func (h *History) record(list *[]time.Time, t time.Time) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	*list = slices.DeleteFunc(append(*list, t), func(other time.Time) bool {
+		return t.Sub(other) > historyWeeks*week
+	})
+	if h.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(h.path, data, 0o644)
+}
+
+// weeks returns how many weeks of joins were observed, at least one
+func (h *History) weeks(now time.Time) int {
+	if len(h.Joins) == 0 {
+		return 1
+	}
+	weeks := int(now.Sub(h.Joins[0])/week) + 1
+	return min(weeks, historyWeeks)
+}
+
+// Predictions returns the join probability of every hour of the week, starting on Sunday
+func (h *History) Predictions(now time.Time) []Prediction {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	seen := map[int]map[int]bool{}
+	for _, join := range h.Joins {
+		join = join.In(now.Location())
+		slot := int(join.Weekday())*24 + join.Hour()
+		if seen[slot] == nil {
+			seen[slot] = map[int]bool{}
+		}
+		seen[slot][int(now.Sub(join)/week)] = true
+	}
+	weeks := h.weeks(now)
+	predictions := make([]Prediction, 0, 7*24)
+	for slot := 0; slot < 7*24; slot++ {
+		predictions = append(predictions, Prediction{
+			Weekday:     time.Weekday(slot / 24).String(),
+			Hour:        slot % 24,
+			Probability: min(float64(len(seen[slot]))/float64(weeks), 1),
+		})
+	}
+	return predictions
+}
+
+// probability returns the join probability of the hour of the week t falls into
+func (h *History) probability(t time.Time) float64 {
+	return h.Predictions(t)[int(t.Weekday())*24+t.Hour()].Probability
+}
+
+// prewarm starts the server ahead of the hours players usually join in.
+// The stop timer gives the pre-warmed server a grace window to be joined.
+func (s *Server) prewarm() {
+	var warmed time.Time
+	for {
+		time.Sleep(prewarmInterval)
+		if s.State == "removed" {
+			return
+		}
+		at := time.Now().Add(s.parent.PrewarmLead)
+		hour := time.Date(at.Year(), at.Month(), at.Day(), at.Hour(), 0, 0, 0, at.Location())
+		if hour.Equal(warmed) || s.State != "stopped" {
+			continue
+		}
+		probability := s.History.probability(at)
+		if probability < s.parent.PrewarmChance {
+			continue
+		}
+		warmed = hour
+		s.Logger.Println("Pre-warming server, players joined around " + hour.Format("Mon 15:04") + " in " +
+			strconv.Itoa(int(probability*100)) + "% of weeks")
+		s.prewarmUntil = at.Add(s.parent.PrewarmGrace)
+		err := s.Start("prewarm")
+		if err != nil {
+			s.Logger.Println(err.Error())
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package crafty
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"time"
+)
+
+const scheduleInterval = time.Minute
+
+// Schedule holds the time windows of a server, the cron expressions being in the schedule's time zone
+type Schedule struct {
+	Timezone string `json:"timezone"`
+	// AlwaysOn windows keep the server running without anyone waking it
+	AlwaysOn []*Window `json:"always_on"`
+	// Curfew windows refuse wakes, and stop the server when they begin
+	Curfew []*Window `json:"curfew"`
+	// BootStart starts the server when the proxy boots
+	BootStart bool `json:"boot_start"`
+
+	location *time.Location
+}
+
+// Window starts whenever Cron fires and lasts for Duration
+type Window struct {
+	Cron     string `json:"cron"`
+	Duration string `json:"duration"`
+
+	cron     *cronExpr
+	duration time.Duration
+}
+
+// LoadSchedules reads the schedule file, which maps server names to schedules,
+// and starts following them
+func (c *Crafty) LoadSchedules(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var schedules map[string]*Schedule
+	err = json.Unmarshal(data, &schedules)
+	if err != nil {
+		return err
+	}
+	for name, schedule := range schedules {
+		err = schedule.parse()
+		if err != nil {
+			return errors.New("schedule of " + name + ": " + err.Error())
+		}
+	}
+	c.schedules = schedules
+	for name := range schedules {
+		if c.Server(name) == nil {
+			c.logger.Println("Schedule for unknown server " + name + " waits for it to be added")
+		}
+	}
+	for _, s := range c.Servers {
+		c.applySchedule(s)
+	}
+	return nil
+}
+
+// applySchedule starts following the server's schedule, if it has one
+func (c *Crafty) applySchedule(s *Server) {
+	for name, schedule := range c.schedules {
+		if strings.EqualFold(name, s.Name) {
+			s.schedule = schedule
+			go s.followSchedule()
+		}
+	}
+}
+
+func (schedule *Schedule) parse() (err error) {
+	schedule.location = time.Local
+	if schedule.Timezone != "" {
+		schedule.location, err = time.LoadLocation(schedule.Timezone)
+		if err != nil {
+			return
+		}
+	}
+	for _, window := range append(schedule.AlwaysOn, schedule.Curfew...) {
+		window.cron, err = parseCron(window.Cron)
+		if err != nil {
+			return
+		}
+		window.duration, err = time.ParseDuration(window.Duration)
+		if err != nil {
+			return
+		}
+		if window.duration <= 0 || window.duration > 7*24*time.Hour {
+			return errors.New("window duration must be between 1m and 168h")
+		}
+	}
+	return nil
+}
+
+// end returns when the window that contains t ends, zero if t is outside the window
+func (window *Window) end(t time.Time) time.Time {
+	start := t.Truncate(time.Minute)
+	for m := start; t.Sub(m) < window.duration; m = m.Add(-time.Minute) {
+		if window.cron.matches(m) {
+			return m.Add(window.duration)
+		}
+	}
+	return time.Time{}
+}
+
+// activeUntil returns when the latest of the active windows ends, zero if none is active
+func (schedule *Schedule) activeUntil(windows []*Window, t time.Time) time.Time {
+	t = t.In(schedule.location)
+	var until time.Time
+	for _, window := range windows {
+		if end := window.end(t); end.After(until) {
+			until = end
+		}
+	}
+	return until
+}
+
+// inAlwaysOn reports whether the server is in an always-on window
+func (s *Server) inAlwaysOn() bool {
+	return s.schedule != nil && !s.schedule.activeUntil(s.schedule.AlwaysOn, time.Now()).IsZero()
+}
+
+// curfewUntil returns when the current curfew ends, zero if there's none
+func (s *Server) curfewUntil() time.Time {
+	if s.schedule == nil {
+		return time.Time{}
+	}
+	return s.schedule.activeUntil(s.schedule.Curfew, time.Now())
+}
+
+// followSchedule starts the server in always-on windows and stops it when a curfew begins
+func (s *Server) followSchedule() {
+	if s.schedule.BootStart && s.curfewUntil().IsZero() {
+		s.Logger.Println("Starting server on boot, as scheduled")
+		_ = s.Start("schedule")
+	}
+	inCurfew := false
+	wasAlwaysOn := false
+	for {
+		if s.State == "removed" {
+			return
+		}
+		until := s.curfewUntil()
+		if !until.IsZero() && !inCurfew {
+			s.Logger.Println("Curfew until " + until.Format("15:04 MST") + " began")
+			if s.IsRunning() || s.State == "starting" {
+				s.stopTimer.Stop()
+				_ = s.Stop()
+			}
+		}
+		inCurfew = !until.IsZero()
+		alwaysOn := s.inAlwaysOn()
+		if !inCurfew && alwaysOn && (s.State == "stopped" || s.State == "crashed") && !s.IsRunning() {
+			s.Logger.Println("Starting server for its always-on window")
+			_ = s.Start("schedule")
+		}
+		if wasAlwaysOn && !alwaysOn && s.State == "running" {
+			s.Logger.Println("Always-on window ended")
+			s.armIdle()
+		}
+		wasAlwaysOn = alwaysOn
+		time.Sleep(scheduleInterval - time.Duration(time.Now().Second())*time.Second)
+	}
+}
+
+func formatCurfew(until time.Time) string {
+	return "wake-ups are not allowed until " + until.Local().Format("15:04 MST")
+}
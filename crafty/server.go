@@ -2,14 +2,18 @@ package crafty
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
 	"os"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Botond24/CraftyProxy/policy"
 	"github.com/Tnze/go-mc/bot"
 )
 
@@ -18,21 +22,68 @@ type fileResponse struct {
 }
 
 type Server struct {
-	Name       string
-	parent     *Crafty
-	InPort     uint16
-	OutPort    uint16
-	AutoOn     bool
-	AutoOff    bool
-	ChangePort bool
-	VoicePort  int
-	id         string
-	Logger     *log.Logger
-	Address    string
-	players    int
-	stopTimer  *time.Timer
-	State      string
-	Handled    bool
+	Name           string
+	parent         *Crafty
+	InPort         uint16
+	OutPort        uint16
+	AutoOn         bool
+	AutoOff        bool
+	ChangePort     bool
+	VoicePort      int
+	BedrockPort    int
+	QueryPort      int
+	RconPort       int
+	RconWake       bool
+	properties     map[string]string
+	Quota          int64
+	Usage          *Usage
+	sessions       sessionList
+	id             string
+	Logger         *log.Logger
+	Address        string
+	players        int
+	stopTimer      *time.Timer
+	State          string
+	Handled        bool
+	maintenance    atomic.Bool
+	maintOption    bool
+	JoinQueue      bool
+	maxPlayers     int
+	online         int
+	AfkKick        bool
+	afkArmed       bool
+	stopping       atomic.Bool
+	Backup         bool
+	BackupId       string
+	BackupRequired bool
+	backingUp      atomic.Bool
+	RestartPolicy  string
+	RestartMax     int
+	startedAt      time.Time
+	pingFailures   int
+	restarts       int
+	lastRestart    time.Time
+	restartGaveUp  bool
+	startMu        sync.Mutex
+	startFailures  int
+	startRetry     time.Time
+	schedule       *Schedule
+	Priority       int
+	lastUsed       time.Time
+	memory         int64
+	admitQueued    atomic.Bool
+	evicting       atomic.Bool
+	evicted        atomic.Bool
+	group          *Group
+	groupStarting  atomic.Bool
+	Prewarm        bool
+	History        *History
+	prewarmUntil   time.Time
+	StartConfirm   time.Duration
+	StartVotes     int
+	VoteWindow     time.Duration
+	policies       map[string]*policy.Program
+	startupTook    time.Duration
 }
 
 func NewServer(parent *Crafty, srv jsonServer) *Server {
@@ -78,6 +129,114 @@ func NewServer(parent *Crafty, srv jsonServer) *Server {
 		}
 		s.VoicePort = p
 	}
+	if v, ok := optionValue(options, "bedrock-port"); ok {
+		p, err := strconv.Atoi(v)
+		if err != nil {
+			s.Logger.Fatalf("Invalid options: %v", options)
+		}
+		s.BedrockPort = p
+	}
+	if v, ok := optionValue(options, "query-port"); ok {
+		p, err := strconv.Atoi(v)
+		if err != nil {
+			s.Logger.Fatalf("Invalid options: %v", options)
+		}
+		s.QueryPort = p
+	}
+	if v, ok := optionValue(options, "rcon-port"); ok {
+		p, err := strconv.Atoi(v)
+		if err != nil {
+			s.Logger.Fatalf("Invalid options: %v", options)
+		}
+		s.RconPort = p
+	}
+	if slices.Contains(options, "rcon-wake") {
+		s.RconWake = true
+	}
+	if v, ok := optionValue(options, "quota"); ok {
+		q, err := ParseBytes(v)
+		if err != nil {
+			s.Logger.Fatalf("Invalid options: %v", options)
+		}
+		s.Quota = q
+	}
+	if v, ok := optionValue(options, "memory"); ok {
+		m, err := ParseBytes(v)
+		if err != nil {
+			s.Logger.Fatalf("Invalid options: %v", options)
+		}
+		s.memory = m
+	}
+	s.Usage = loadUsage(parent.DataDir, s.id)
+	s.History = loadHistory(parent.DataDir, s.id)
+	if slices.Contains(options, "prewarm") {
+		s.Prewarm = true
+	}
+	if slices.Contains(options, "backup") {
+		s.Backup = true
+	}
+	if v, ok := optionValue(options, "backup"); ok {
+		s.Backup = true
+		s.BackupId = v
+	}
+	if slices.Contains(options, "backup-required") {
+		s.Backup = true
+		s.BackupRequired = true
+	}
+	s.RestartPolicy = "never"
+	s.RestartMax = 3
+	if v, ok := optionValue(options, "restart"); ok {
+		if v != "never" && v != "on-failure" {
+			s.Logger.Fatalf("Invalid options: %v", options)
+		}
+		s.RestartPolicy = v
+	}
+	if v, ok := optionValue(options, "restart-max"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			s.Logger.Fatalf("Invalid options: %v", options)
+		}
+		s.RestartMax = n
+	}
+	if v, ok := optionValue(options, "priority"); ok {
+		p, err := strconv.Atoi(v)
+		if err != nil {
+			s.Logger.Fatalf("Invalid options: %v", options)
+		}
+		s.Priority = p
+	}
+	if v, ok := optionValue(options, "start-confirm"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			s.Logger.Fatalf("Invalid options: %v", options)
+		}
+		s.StartConfirm = time.Duration(n) * time.Second
+	}
+	s.VoteWindow = 5 * time.Minute
+	if v, ok := optionValue(options, "start-votes"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			s.Logger.Fatalf("Invalid options: %v", options)
+		}
+		s.StartVotes = n
+	}
+	if v, ok := optionValue(options, "vote-window"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			s.Logger.Fatalf("Invalid options: %v", options)
+		}
+		s.VoteWindow = time.Duration(n) * time.Second
+	}
+	if slices.Contains(options, "afk-kick") {
+		s.AfkKick = true
+	}
+	if slices.Contains(options, "queue") {
+		s.JoinQueue = true
+	}
+	if slices.Contains(options, "maintenance") {
+		s.maintOption = true
+		s.maintenance.Store(true)
+	}
 	if s.AutoOn || s.AutoOff {
 		if s.ChangePort {
 			s.updatePort()
@@ -85,17 +244,73 @@ func NewServer(parent *Crafty, srv jsonServer) *Server {
 			s.InPort = s.OutPort
 		}
 	}
+	if s.RconPort != 0 && s.properties == nil {
+		s.loadProperties()
+	}
 	s.stopTimer = time.AfterFunc(parent.StopTimeout*time.Minute, func() {
-		s.Stop()
+		if s.players > 0 && !s.allAfk() {
+			return
+		}
+		if s.unproxiedPlayers() {
+			s.Logger.Println("Not stopping server, " + strconv.Itoa(s.online) + " players are online on it, checking again in " + strconv.Itoa(int(s.parent.StopTimeout)) + " minutes")
+			s.stopTimer.Reset(s.parent.StopTimeout * time.Minute)
+			return
+		}
+		if s.inAlwaysOn() {
+			s.Logger.Println("Not stopping server, it is in an always-on window")
+			return
+		}
+		if !s.Decide(DecisionStop, true, "", "", "") {
+			s.Logger.Println("Not stopping server, the stop policy refused, checking again in " + strconv.Itoa(int(s.parent.StopTimeout)) + " minutes")
+			s.stopTimer.Reset(s.parent.StopTimeout * time.Minute)
+			return
+		}
+		s.idleStop()
 	})
 	s.stopTimer.Stop()
-	s.IsRunning()
+	// the initial state is set without events, the watchdog takes over from there
+	s.State = "stopped"
+	if running, err := s.craftyRunning(); err == nil && running && s.checkPing() {
+		s.State = "running"
+	}
 	return s
 }
 func containsVoice(str string) bool {
 	return strings.Contains(str, "voice-port")
 }
 
+// optionValue returns the value of a key=value option from the server name
+func optionValue(options []string, key string) (string, bool) {
+	for _, option := range options {
+		k, v, found := strings.Cut(option, "=")
+		if found && strings.TrimSpace(k) == key {
+			return strings.TrimSpace(v), true
+		}
+	}
+	return "", false
+}
+
+// ListenPort returns the public port for an optional extra listener,
+// -1 meaning the same port as the game
+func (s *Server) ListenPort(port int) int {
+	if port == -1 {
+		return int(s.OutPort)
+	}
+	return port
+}
+
+// BackendPort returns the port the backend listens on for an optional extra listener.
+// With update-port the backend is expected 2000 ports below the public one, like the game port.
+func (s *Server) BackendPort(port int) int {
+	if port == -1 {
+		return int(s.InPort)
+	}
+	if s.ChangePort {
+		return port - 2000
+	}
+	return port
+}
+
 func (s *Server) String() string {
 	return s.Name + " (" + strconv.Itoa(int(s.OutPort)) + "->" + strconv.Itoa(int(s.InPort)) + ")" + "\n" +
 		"\tAuto on: " + strconv.FormatBool(s.AutoOn) + "\n" +
@@ -104,35 +319,87 @@ func (s *Server) String() string {
 		"\tID: " + s.id
 }
 
-func (s *Server) Start(name string) {
-	if s.State != "stopped" {
-		return
+// Start asks Crafty to start the server on behalf of name. Concurrent calls are serialized,
+// so only the first one starts the server, and failed starts put it into a growing cooldown.
+func (s *Server) Start(name string) error {
+	s.startMu.Lock()
+	defer s.startMu.Unlock()
+	if s.State != "stopped" && s.State != "crashed" {
+		return nil
+	}
+	if time.Now().Before(s.startRetry) {
+		return &StartError{Reason: "the last start failed", RetryAt: s.startRetry}
+	}
+	if s.backingUp.Load() {
+		s.Logger.Println("Not starting server for " + name + ", a backup is running")
+		return &StartError{Reason: "a backup is running"}
+	}
+	if s.OverQuota() {
+		s.Logger.Println("Not starting server for " + name + ", the monthly bandwidth quota is used up")
+		return &StartError{Reason: "the monthly bandwidth quota is used up"}
 	}
-	post, err := s.parent.Post("/api/v2/servers/"+s.id+"/action/start_server", []byte{})
+	if s.InMaintenance() && !s.parent.IsAdmin(name) {
+		s.Logger.Println("Not starting server for " + name + ", it is in maintenance")
+		return &StartError{Reason: "the server is in maintenance"}
+	}
+	if until := s.curfewUntil(); !until.IsZero() && !s.parent.IsAdmin(name) {
+		s.Logger.Println("Not starting server for " + name + ", it has a curfew")
+		return &StartError{Reason: formatCurfew(until)}
+	}
+	if !s.dependenciesReady() {
+		if s.groupStarting.CompareAndSwap(false, true) {
+			go s.startWithDependencies(name)
+		}
+		return nil
+	}
+	s.parent.capMu.Lock()
+	defer s.parent.capMu.Unlock()
+	// admit first, so no server is evicted for a start the host refuses anyway
+	err := s.admit(name)
 	if err != nil {
-		s.Logger.Println("Can't start server: " + err.Error())
-		return
+		return err
 	}
-	if post.StatusCode != 200 {
-		s.Logger.Println("Can't start server: " + post.Status)
-		return
+	err = s.makeRoom(name)
+	if err == errMakingRoom {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = s.action("start_server")
+	if err != nil {
+		s.startFailed(name, err)
+		return &StartError{Reason: err.Error(), RetryAt: s.startRetry}
 	}
 	s.Logger.Println("Server started by " + name)
+	s.parent.emit(Event{Type: EventWaking, Server: s.Name, Player: name, Message: "started by " + name})
 	s.State = "starting"
+	s.startedAt = time.Now()
+	s.lastUsed = s.startedAt
+	err = s.History.record(&s.History.Wakes, s.startedAt)
+	if err != nil {
+		s.Logger.Println("Can't save history: " + err.Error())
+	}
+	s.pingFailures = 0
+	s.startFailures = 0
+	if name != "restart policy" {
+		s.restarts = 0
+		s.restartGaveUp = false
+	}
+	return nil
 }
 
-func (s *Server) Stop() {
-	post, err := s.parent.Post("/api/v2/servers/"+s.id+"/action/stop_server", []byte{})
+// SendCommand runs a console command on the server through Crafty
+func (s *Server) SendCommand(command string) error {
+	post, err := s.parent.Post("/api/v2/servers/"+s.id+"/stdin", []byte(command))
 	if err != nil {
-		s.Logger.Println("Can't stop server: " + err.Error())
-		return
+		return err
 	}
+	defer post.Body.Close()
 	if post.StatusCode != 200 {
-		s.Logger.Println("Can't stop server: " + post.Status)
-		return
+		return errors.New(post.Status)
 	}
-	s.Logger.Println("Stopped server")
-	s.State = "stopped"
+	return nil
 }
 
 func (s *Server) updatePort() {
@@ -158,12 +425,25 @@ func (s *Server) updatePort() {
 		json.Unmarshal(postBody, &file)
 		body = file.Data
 	}
+	s.parseProperties(body)
 
 	lines := strings.Split(body, "\n")
 	for i, line := range lines {
 		if strings.Contains(line, "server-port=") {
 			lines[i] = "server-port=" + strconv.Itoa(int(s.InPort))
 		}
+		if s.QueryPort != 0 && strings.HasPrefix(line, "query.port=") {
+			lines[i] = "query.port=" + strconv.Itoa(s.BackendPort(s.QueryPort))
+		}
+		if s.QueryPort != 0 && strings.HasPrefix(line, "enable-query=") {
+			lines[i] = "enable-query=true"
+		}
+		if s.RconPort != 0 && strings.HasPrefix(line, "rcon.port=") {
+			lines[i] = "rcon.port=" + strconv.Itoa(s.BackendPort(s.RconPort))
+		}
+		if s.RconPort != 0 && strings.HasPrefix(line, "enable-rcon=") {
+			lines[i] = "enable-rcon=true"
+		}
 	}
 	body = strings.Join(lines, "\n")
 	body = "{\"path\":\"" + path + "\",\"contents\":\"" + strings.ReplaceAll(
@@ -185,6 +465,48 @@ func (s *Server) updatePort() {
 
 }
 
+// loadProperties reads server.properties without changing it
+func (s *Server) loadProperties() {
+	path := "servers/" + s.id + "/server.properties"
+	props := "{\"path\":\"" + path + "\"}"
+	post, err := s.parent.Post("/api/v2/servers/"+s.id+"/files", []byte(props))
+	if err != nil {
+		s.Logger.Println("Can't read server.properties: " + err.Error())
+		return
+	}
+	defer post.Body.Close()
+	if post.StatusCode != 200 {
+		s.Logger.Println("Can't read server.properties: " + post.Status)
+		return
+	}
+	postBody, err := io.ReadAll(post.Body)
+	if err != nil {
+		s.Logger.Println("Can't read response body: " + err.Error())
+		return
+	}
+	var file fileResponse
+	json.Unmarshal(postBody, &file)
+	s.parseProperties(file.Data)
+}
+
+func (s *Server) parseProperties(body string) {
+	s.properties = map[string]string{}
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if found {
+			s.properties[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+}
+
+// Property returns a value from the server's server.properties, empty if it isn't known
+func (s *Server) Property(key string) string {
+	return s.properties[key]
+}
+
 func (s *Server) createProperties() {
 	body := "{\"parent\":\"servers/" + s.id + "\",\"name\": \"server.property\",\"directory\": false}"
 	put, err := s.parent.Put("/api/v2/servers/"+s.id+"/files/servers/"+s.id+"/server.properties", []byte(body))
@@ -197,36 +519,63 @@ func (s *Server) createProperties() {
 	}
 }
 
-func (s *Server) IsRunning() bool {
+// getStats returns the server's stats as reported by Crafty
+func (s *Server) getStats() (map[string]interface{}, error) {
 	get, err := s.parent.Get("/api/v2/servers/" + s.id + "/stats")
 	if err != nil {
-		s.Logger.Println("Can't get server stats: " + err.Error() + "\n")
-		return false
+		return nil, errors.New("Can't get server stats: " + err.Error())
 	}
 	defer get.Body.Close()
 	body, err := io.ReadAll(get.Body)
 	if err != nil {
-		s.Logger.Println("Can't read response body: " + err.Error() + "\n")
-		return false
+		return nil, errors.New("Can't read response body: " + err.Error())
 	}
 	var stats map[string]interface{}
 	err = json.Unmarshal(body, &stats)
 	if err != nil {
-		s.Logger.Println("Can't extract JSON to object: " + err.Error() + "\n")
+		return nil, errors.New("Can't extract JSON to object: " + err.Error())
+	}
+	data, ok := stats["data"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("Can't get server stats: " + string(body))
+	}
+	return data, nil
+}
+
+// craftyRunning reports whether Crafty has the server process running, without pinging it
+func (s *Server) craftyRunning() (bool, error) {
+	stats, err := s.getStats()
+	if err != nil {
+		return false, err
+	}
+	running, _ := stats["running"].(bool)
+	return running, nil
+}
+
+func (s *Server) IsRunning() bool {
+	if s.stopping.Load() || s.backingUp.Load() {
+		return false
+	}
+	isrunning, err := s.craftyRunning()
+	if err != nil {
+		s.Logger.Println(err.Error())
 		return false
 	}
-	isrunning := stats["data"].(map[string]interface{})["running"].(bool)
-	if isrunning {
-		if s.checkPing() {
-			s.State = "running"
-			return true
+	// only the watchdog moves servers to stopped, as Crafty may not report a just started process yet
+	if isrunning && s.checkPing() {
+		if s.State != "running" {
+			s.setState("running", "the server answers pings")
 		}
-		return false
+		return true
 	}
-	s.State = "stopped"
 	return false
 }
 
+// Players returns the number of players connected through the proxy
+func (s *Server) Players() int {
+	return s.players
+}
+
 func (s *Server) IncrementPlayers() {
 	s.players++
 	s.Logger.Println("Players: " + strconv.Itoa(s.players))
@@ -248,8 +597,30 @@ func (s *Server) DecrementPlayers() {
 	}
 }
 
+// InMaintenance reports whether the server only lets admins join and wake it
+func (s *Server) InMaintenance() bool {
+	return s.maintenance.Load()
+}
+
+// SetMaintenance turns maintenance mode on or off
+func (s *Server) SetMaintenance(on bool, by string) {
+	if s.maintenance.Swap(on) == on {
+		return
+	}
+	if on {
+		s.Logger.Println("Maintenance mode enabled by " + by)
+	} else {
+		s.Logger.Println("Maintenance mode disabled by " + by)
+	}
+}
+
+// IsAdmin reports whether a player may bypass maintenance mode
+func (s *Server) IsAdmin(name string) bool {
+	return s.parent.IsAdmin(name)
+}
+
 func (s *Server) Remove() {
-	s.parent.Servers = slices.DeleteFunc(s.parent.Servers, func(server Server) bool {
+	s.parent.Servers = slices.DeleteFunc(s.parent.Servers, func(server *Server) bool {
 		return server.id == s.id
 	})
 }
@@ -267,13 +638,38 @@ func (s *Server) FixName(inname string) (name string, options []string) {
 }
 
 func (s *Server) checkPing() bool {
-	_, _, err := bot.PingAndList(s.Address + ":" + strconv.Itoa(int(s.InPort)))
+	resp, _, err := bot.PingAndList(s.Address + ":" + strconv.Itoa(int(s.InPort)))
 	if err != nil {
 		return false
 	}
+	var status struct {
+		Players struct {
+			Max    int `json:"max"`
+			Online int `json:"online"`
+		} `json:"players"`
+	}
+	if json.Unmarshal(resp, &status) == nil {
+		s.maxPlayers = status.Players.Max
+		s.online = status.Players.Online
+	}
 	return true
 }
 
+// unproxiedPlayers reports whether the backend has more players online than the proxy
+// sees, like Bedrock players coming through the UDP relay
+func (s *Server) unproxiedPlayers() bool {
+	return s.checkPing() && s.online > s.players
+}
+
+// MaxPlayers returns the backend's player limit, 0 if it isn't known
+func (s *Server) MaxPlayers() int {
+	if s.maxPlayers > 0 {
+		return s.maxPlayers
+	}
+	max, _ := strconv.Atoi(s.Property("max-players"))
+	return max
+}
+
 const (
 	defaultServerProperties = "allow-flight=true\\n" +
 		"allow-nether=true\\n" +
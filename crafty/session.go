@@ -0,0 +1,178 @@
+package crafty
+
+import (
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Session is a single proxied player connection
+type Session struct {
+	Player  string
+	UUID    string
+	Addr    string
+	Started time.Time
+	in      atomic.Int64
+	out     atomic.Int64
+
+	// activity tracking, inbound bytes per afkWindow tell active play from keepalives
+	mu          sync.Mutex
+	windowStart time.Time
+	windowBytes int64
+	lastActive  time.Time
+	// accounted is how far the session's playtime was counted
+	accounted time.Time
+	conn      net.Conn
+}
+
+const afkWindow = time.Minute
+
+// DefaultAfkBytes is the inbound bytes per minute that count as playing. An idle client
+// on 1.21.2 or later still sends Client Tick End every tick and a position reminder
+// every second, about 6 KiB a minute with keepalives, while walking sends a movement
+// packet every tick, 30 KiB or more.
+const DefaultAfkBytes = 16 << 10
+
+// Traffic returns the bytes the session moved so far
+func (session *Session) Traffic() Traffic {
+	return Traffic{In: session.in.Load(), Out: session.out.Load()}
+}
+
+type sessionList struct {
+	mu       sync.Mutex
+	sessions []*Session
+}
+
+// OpenSession registers a new player connection
+func (s *Server) OpenSession(player string, id string, conn net.Conn) *Session {
+	now := time.Now()
+	session := &Session{Player: player, UUID: id, Addr: conn.RemoteAddr().String(), Started: now,
+		windowStart: now, lastActive: now, accounted: now, conn: conn}
+	s.sessions.mu.Lock()
+	s.sessions.sessions = append(s.sessions.sessions, session)
+	s.sessions.mu.Unlock()
+	s.lastUsed = now
+	s.parent.emit(Event{Type: EventPlayerJoined, Server: s.Name, Player: player, Message: player + " joined from " + session.Addr})
+	err := s.History.record(&s.History.Joins, now)
+	if err != nil {
+		s.Logger.Println("Can't save history: " + err.Error())
+	}
+	return session
+}
+
+// CloseSession unregisters a player connection and reports what it used
+func (s *Server) CloseSession(session *Session) {
+	s.sessions.mu.Lock()
+	s.sessions.sessions = slices.DeleteFunc(s.sessions.sessions, func(other *Session) bool {
+		return other == session
+	})
+	s.sessions.mu.Unlock()
+	s.lastUsed = time.Now()
+	if s.parent.Playtime != nil {
+		s.parent.Playtime.account(session, s.lastUsed)
+	}
+	s.Logger.Println("Session of " + session.Player + " ended after " +
+		time.Since(session.Started).Round(time.Second).String() + ": " + session.Traffic().String())
+	s.parent.emit(Event{Type: EventPlayerLeft, Server: s.Name, Player: session.Player,
+		Message: session.Player + " left after " + time.Since(session.Started).Round(time.Second).String()})
+	err := s.Usage.save()
+	if err != nil {
+		s.Logger.Println("Can't save bandwidth usage: " + err.Error())
+	}
+}
+
+// Sessions returns the currently open player connections
+func (s *Server) Sessions() []*Session {
+	s.sessions.mu.Lock()
+	defer s.sessions.mu.Unlock()
+	return slices.Clone(s.sessions.sessions)
+}
+
+// AddTraffic counts bytes moved for the server, and for the session if it's not nil
+func (s *Server) AddTraffic(session *Session, in int64, out int64) {
+	if in == 0 && out == 0 {
+		return
+	}
+	player := ""
+	if session != nil {
+		session.in.Add(in)
+		session.out.Add(out)
+		player = session.Player
+		if in > 0 {
+			session.recordInbound(in, time.Now(), s.parent.AfkBytes)
+		}
+	}
+	now := time.Now()
+	closedHour, closedDay := s.Usage.add(player, Traffic{In: in, Out: out}, now)
+	if closedHour != "" {
+		hour, _ := time.ParseInLocation(hourFormat, closedHour, time.Local)
+		s.Logger.Println("Bandwidth for the hour " + hour.Format("2006-01-02 15:00") + ": " + s.Usage.Hour(hour).String())
+		err := s.Usage.save()
+		if err != nil {
+			s.Logger.Println("Can't save bandwidth usage: " + err.Error())
+		}
+	}
+	if closedDay != "" {
+		day, _ := time.ParseInLocation(dayFormat, closedDay, time.Local)
+		s.Logger.Println("Bandwidth for " + closedDay + ": " + s.Usage.Day(day).String())
+	}
+	if s.Usage.reachedQuota(s.Quota, now) {
+		s.Logger.Println("Monthly bandwidth quota of " + FormatBytes(s.Quota) + " reached, stopping server")
+		s.parent.emit(Event{Type: EventQuotaReached, Server: s.Name, Message: "monthly bandwidth quota of " + FormatBytes(s.Quota) + " reached"})
+		go func() {
+			_ = s.Stop()
+		}()
+	}
+}
+
+// recordInbound marks the session active once the bytes sent in the current window pass the threshold.
+// The packets can't be told apart, as they are encrypted in online mode, so the threshold has
+// to sit between what idle clients send every tick and what moving players send.
+func (session *Session) recordInbound(n int64, now time.Time, threshold int64) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if now.Sub(session.windowStart) >= afkWindow {
+		session.windowStart = now
+		session.windowBytes = 0
+	}
+	session.windowBytes += n
+	if session.windowBytes >= threshold {
+		session.lastActive = now
+	}
+}
+
+// Disconnect closes the player's connection
+func (session *Session) Disconnect() {
+	_ = session.conn.Close()
+}
+
+// IdleFor returns how long the player hasn't been actively playing
+func (session *Session) IdleFor() time.Duration {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return time.Since(session.lastActive)
+}
+
+// allAfk reports whether players are connected and all of them are AFK
+func (s *Server) allAfk() bool {
+	if s.parent.AfkTimeout <= 0 {
+		return false
+	}
+	sessions := s.Sessions()
+	if len(sessions) == 0 {
+		return false
+	}
+	for _, session := range sessions {
+		if session.IdleFor() < s.parent.AfkTimeout {
+			return false
+		}
+	}
+	return true
+}
+
+// OverQuota reports whether the server used up its monthly bandwidth quota
+func (s *Server) OverQuota() bool {
+	return s.Quota > 0 && s.Usage.Month(time.Now()).Total() >= s.Quota
+}
@@ -0,0 +1,48 @@
+package crafty
+
+import (
+	"strconv"
+	"time"
+)
+
+const (
+	startBackoff    = 15 * time.Second
+	maxStartBackoff = 10 * time.Minute
+	// startEscalation is how many failed starts in a row are reported as escalated
+	startEscalation = 3
+)
+
+// StartError is returned when a server can't be started, RetryAt being
+// when the next attempt is allowed if the start failed
+type StartError struct {
+	Reason  string
+	RetryAt time.Time
+}
+
+func (e *StartError) Error() string {
+	if e.RetryAt.IsZero() {
+		return "Can't start server: " + e.Reason
+	}
+	return "Can't start server: " + e.Reason + ", try again in " + time.Until(e.RetryAt).Round(time.Second).String()
+}
+
+// startFailed puts the server into a cooldown that doubles with every failure in a row
+func (s *Server) startFailed(name string, err error) {
+	s.startFailures++
+	cooldown := startBackoff << (s.startFailures - 1)
+	if cooldown > maxStartBackoff || cooldown <= 0 {
+		cooldown = maxStartBackoff
+	}
+	s.startRetry = time.Now().Add(cooldown)
+	s.Logger.Println("Can't start server for " + name + " (failure " + strconv.Itoa(s.startFailures) + "): " +
+		err.Error() + ", next attempt allowed in " + cooldown.String())
+	s.parent.emit(Event{Type: EventStartFailed, Server: s.Name, Player: name, Message: err.Error()})
+	if s.startFailures >= startEscalation {
+		s.parent.emit(Event{
+			Type:    EventStartEscalated,
+			Server:  s.Name,
+			Player:  name,
+			Message: "Start failed " + strconv.Itoa(s.startFailures) + " times in a row: " + err.Error(),
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package crafty
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+const (
+	saveDelay         = 5 * time.Second
+	stopPollInterval  = 2 * time.Second
+	killConfirmPeriod = 30 * time.Second
+)
+
+// Stop shuts the server down gracefully: it warns the players, saves the world,
+// asks Crafty to stop the server and waits until Crafty reports it stopped.
+// If that takes longer than the kill timeout, the server is killed.
+func (s *Server) Stop() error {
+	if !s.stopping.CompareAndSwap(false, true) {
+		s.Logger.Println("Stop: already stopping")
+		return nil
+	}
+	defer s.stopping.Store(false)
+	started := time.Now()
+	previous := s.State
+	s.State = "stopping"
+
+	if s.players > 0 {
+		s.warnStop()
+	}
+	s.Logger.Println("Stop: saving the world")
+	err := s.SendCommand("save-all")
+	if err != nil {
+		s.Logger.Println("Stop: can't save the world: " + err.Error())
+	} else {
+		time.Sleep(saveDelay)
+	}
+
+	s.Logger.Println("Stop: stopping server")
+	err = s.action("stop_server")
+	if err != nil {
+		s.State = previous
+		s.Logger.Println("Can't stop server: " + err.Error())
+		return err
+	}
+	if s.waitStopped(s.parent.KillTimeout) {
+		s.setState("stopped", "stopped in "+time.Since(started).Round(time.Second).String())
+		return nil
+	}
+
+	s.Logger.Println("Stop: server didn't stop in " + s.parent.KillTimeout.String() + ", killing it")
+	err = s.action("kill_server")
+	if err != nil {
+		s.State = previous
+		s.Logger.Println("Can't kill server: " + err.Error())
+		return err
+	}
+	if s.waitStopped(killConfirmPeriod) {
+		s.setState("stopped", "killed after "+time.Since(started).Round(time.Second).String())
+		return nil
+	}
+	s.State = previous
+	err = errors.New("server is still running after being killed")
+	s.Logger.Println("Can't stop server: " + err.Error())
+	return err
+}
+
+// StopBy stops the server gracefully on behalf of name, cancelling a pending idle stop
+func (s *Server) StopBy(name string) error {
+	s.Logger.Println("Server stop requested by " + name)
+	s.stopTimer.Stop()
+	return s.Stop()
+}
+
+// StartupETA estimates how long a starting server still needs from how long its
+// last start took, 0 if that isn't known
+func (s *Server) StartupETA() time.Duration {
+	if s.State != "starting" || s.startupTook == 0 {
+		return 0
+	}
+	return max(s.startupTook-time.Since(s.startedAt), 0)
+}
+
+// warnStop counts down in the chat with the configured warnings, longest first
+func (s *Server) warnStop() {
+	warnings := s.parent.StopWarnings
+	for i, warning := range warnings {
+		s.Logger.Println("Stop: warning players, " + warning.String() + " left")
+		err := s.SendCommand("say Server stopping in " + strconv.Itoa(int(warning.Seconds())) + " seconds")
+		if err != nil {
+			s.Logger.Println("Stop: can't warn players: " + err.Error())
+		}
+		next := time.Duration(0)
+		if i+1 < len(warnings) {
+			next = warnings[i+1]
+		}
+		time.Sleep(warning - next)
+	}
+}
+
+// action runs a server action like stop_server through Crafty
+func (s *Server) action(action string) error {
+	post, err := s.parent.Post("/api/v2/servers/"+s.id+"/action/"+action, []byte{})
+	if err != nil {
+		return err
+	}
+	defer post.Body.Close()
+	if post.StatusCode != 200 {
+		return errors.New(post.Status)
+	}
+	return nil
+}
+
+// waitStopped polls Crafty until it reports the server stopped, or the timeout passes
+func (s *Server) waitStopped(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		running, err := s.craftyRunning()
+		if err != nil {
+			s.Logger.Println("Stop: " + err.Error())
+		} else if !running {
+			return true
+		}
+		time.Sleep(stopPollInterval)
+	}
+	return false
+}
@@ -0,0 +1,238 @@
+package crafty
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	hourFormat  = "2006-01-02T15"
+	dayFormat   = "2006-01-02"
+	monthFormat = "2006-01"
+
+	keepHours  = 48
+	keepDays   = 62
+	keepMonths = 12
+)
+
+// Traffic is a pair of byte counters, In being the bytes sent by clients
+type Traffic struct {
+	In  int64 `json:"in"`
+	Out int64 `json:"out"`
+}
+
+func (t Traffic) Total() int64 {
+	return t.In + t.Out
+}
+
+func (t Traffic) String() string {
+	return FormatBytes(t.In) + " in, " + FormatBytes(t.Out) + " out"
+}
+
+// Usage is the bandwidth used by a server, rolled up per hour, day and month
+type Usage struct {
+	mu    sync.Mutex
+	path  string
+	dirty bool
+	// quotaMonth is the last month the quota was reported as reached
+	quotaMonth string
+	Hourly     map[string]Traffic            `json:"hourly"`
+	Daily      map[string]Traffic            `json:"daily"`
+	Monthly    map[string]Traffic            `json:"monthly"`
+	Players    map[string]map[string]Traffic `json:"players"`
+}
+
+// loadUsage reads the usage file from the data dir, or starts from zero if there's none
+func loadUsage(dataDir string, id string) *Usage {
+	u := &Usage{
+		Hourly:  map[string]Traffic{},
+		Daily:   map[string]Traffic{},
+		Monthly: map[string]Traffic{},
+		Players: map[string]map[string]Traffic{},
+	}
+	if dataDir == "" {
+		return u
+	}
+	u.path = filepath.Join(dataDir, "usage-"+id+".json")
+	data, err := os.ReadFile(u.path)
+	if err != nil {
+		return u
+	}
+	_ = json.Unmarshal(data, u)
+	return u
+}
+
+// add counts traffic for a player (empty if not known) and returns the hour and day
+// that were closed by this call, if any, so they can be reported
+func (u *Usage) add(player string, traffic Traffic, now time.Time) (closedHour, closedDay string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	hour := now.Format(hourFormat)
+	day := now.Format(dayFormat)
+	if _, ok := u.Hourly[hour]; !ok {
+		closedHour = latest(u.Hourly)
+		prune(u.Hourly, keepHours)
+	}
+	if _, ok := u.Daily[day]; !ok {
+		closedDay = latest(u.Daily)
+		prune(u.Daily, keepDays)
+		for name, days := range u.Players {
+			prune(days, keepDays)
+			if len(days) == 0 {
+				delete(u.Players, name)
+			}
+		}
+	}
+	u.Hourly[hour] = sum(u.Hourly[hour], traffic)
+	u.Daily[day] = sum(u.Daily[day], traffic)
+	month := now.Format(monthFormat)
+	if _, ok := u.Monthly[month]; !ok {
+		prune(u.Monthly, keepMonths)
+	}
+	u.Monthly[month] = sum(u.Monthly[month], traffic)
+	if player != "" {
+		if u.Players[player] == nil {
+			u.Players[player] = map[string]Traffic{}
+		}
+		u.Players[player][day] = sum(u.Players[player][day], traffic)
+	}
+	u.dirty = true
+	return
+}
+
+func (u *Usage) Hour(t time.Time) Traffic {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.Hourly[t.Format(hourFormat)]
+}
+
+func (u *Usage) Day(t time.Time) Traffic {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.Daily[t.Format(dayFormat)]
+}
+
+func (u *Usage) Month(t time.Time) Traffic {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.Monthly[t.Format(monthFormat)]
+}
+
+// PlayerDay returns the traffic of a single player on a day
+func (u *Usage) PlayerDay(player string, t time.Time) Traffic {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.Players[player][t.Format(dayFormat)]
+}
+
+// reachedQuota reports, once per month, that the monthly traffic reached the quota
+func (u *Usage) reachedQuota(quota int64, now time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	month := now.Format(monthFormat)
+	if quota <= 0 || u.quotaMonth == month || u.Monthly[month].Total() < quota {
+		return false
+	}
+	u.quotaMonth = month
+	return true
+}
+
+// save writes the usage file if anything changed since the last save
+func (u *Usage) save() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.path == "" || !u.dirty {
+		return nil
+	}
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(u.path+".tmp", data, 0o644)
+	if err != nil {
+		return err
+	}
+	u.dirty = false
+	return os.Rename(u.path+".tmp", u.path)
+}
+
+func sum(a Traffic, b Traffic) Traffic {
+	return Traffic{In: a.In + b.In, Out: a.Out + b.Out}
+}
+
+// latest returns the newest key of a rollup, keys sort chronologically
+func latest(m map[string]Traffic) string {
+	newest := ""
+	for k := range m {
+		if k > newest {
+			newest = k
+		}
+	}
+	return newest
+}
+
+// prune drops the oldest keys so that at most keep-1 remain, making room for a new one
+func prune(m map[string]Traffic, keep int) {
+	if len(m) < keep {
+		return
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys[:len(keys)-keep+1] {
+		delete(m, k)
+	}
+}
+
+var byteUnits = []string{"B", "KiB", "MiB", "GiB", "TiB"}
+
+// FormatBytes formats a byte count with a binary unit
+func FormatBytes(n int64) string {
+	value := float64(n)
+	unit := 0
+	for value >= 1024 && unit < len(byteUnits)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return strconv.FormatInt(n, 10) + " B"
+	}
+	return strconv.FormatFloat(value, 'f', 1, 64) + " " + byteUnits[unit]
+}
+
+// ParseBytes parses sizes like 500M or 20G (binary units), a plain number being bytes
+func ParseBytes(str string) (int64, error) {
+	str = strings.ToUpper(strings.TrimSpace(str))
+	str = strings.TrimSuffix(strings.TrimSuffix(str, "B"), "I")
+	multiplier := int64(1)
+	if str == "" {
+		return 0, errors.New("empty size")
+	}
+	switch str[len(str)-1] {
+	case 'K':
+		multiplier = 1 << 10
+	case 'M':
+		multiplier = 1 << 20
+	case 'G':
+		multiplier = 1 << 30
+	case 'T':
+		multiplier = 1 << 40
+	}
+	if multiplier != 1 {
+		str = str[:len(str)-1]
+	}
+	n, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(n * float64(multiplier)), nil
+}
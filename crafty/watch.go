@@ -0,0 +1,200 @@
+package crafty
+
+import (
+	"strconv"
+	"time"
+)
+
+const (
+	watchInterval = 30 * time.Second
+	// unresponsiveChecks is how many failed pings in a row make a running server unresponsive
+	unresponsiveChecks = 3
+	restartBackoff     = 10 * time.Second
+	// healthyReset is how long a server has to run fine for its restart count to be reset
+	healthyReset = 10 * time.Minute
+)
+
+// watch follows the server's state, so that a server nobody joins after it came up,
+// including one started from the Crafty panel, still gets stopped when idle,
+// and crashed or hung servers are noticed and restarted
+func (s *Server) watch() {
+	wasRunning := false
+	for {
+		if s.State == "removed" {
+			return
+		}
+		if !s.stopping.Load() && !s.backingUp.Load() {
+			running := s.checkHealth()
+			if running && !wasRunning {
+				s.armIdle()
+			}
+			if running && s.AutoOff {
+				s.checkAfk()
+			}
+			wasRunning = running
+		}
+		time.Sleep(watchInterval)
+	}
+}
+
+// checkHealth tells starting, running, unresponsive and crashed servers apart using Crafty's stats,
+// the ping history and the startup deadline, and applies the restart policy. It reports whether
+// the server is running.
+func (s *Server) checkHealth() bool {
+	stats, err := s.getStats()
+	if err != nil {
+		s.Logger.Println(err.Error())
+		return s.State == "running"
+	}
+	running, _ := stats["running"].(bool)
+	crashed, _ := stats["crashed"].(bool)
+	if !running {
+		switch {
+		case crashed && s.State != "crashed":
+			s.setState("crashed", "Crafty reports the server crashed")
+		case s.State == "starting" && time.Since(s.startedAt) > s.parent.StartupTimeout:
+			s.setState("crashed", "the server didn't come up in "+s.parent.StartupTimeout.String())
+		case s.State == "starting" || s.State == "crashed":
+			// Crafty may not report a just started process yet, and crashed stays until restarted
+		default:
+			s.setState("stopped", "Crafty reports the server stopped")
+		}
+		if s.State == "crashed" {
+			s.applyRestart()
+		}
+		return false
+	}
+
+	if s.checkPing() {
+		s.pingFailures = 0
+		if s.State != "running" {
+			s.setState("running", "the server answers pings")
+		}
+		if s.restarts > 0 && time.Since(s.lastRestart) > healthyReset {
+			s.restarts = 0
+		}
+		return true
+	}
+	s.pingFailures++
+	switch s.State {
+	case "stopped", "crashed":
+		// started from outside the proxy, or by Crafty itself
+		s.startedAt = time.Now()
+		s.setState("starting", "Crafty reports the server running")
+	case "starting":
+		if time.Since(s.startedAt) > s.parent.StartupTimeout {
+			s.setState("unresponsive", "the server didn't answer pings "+s.parent.StartupTimeout.String()+" after starting")
+		}
+	case "running":
+		if s.pingFailures >= unresponsiveChecks {
+			s.setState("unresponsive", "the server didn't answer "+strconv.Itoa(s.pingFailures)+" pings")
+		}
+	}
+	if s.State == "unresponsive" {
+		s.applyRestart()
+	}
+	return false
+}
+
+// setState changes the state, emitting an event for the lifecycle states
+func (s *Server) setState(state string, reason string) {
+	s.Logger.Println("Server is " + state + ": " + reason)
+	previous := s.State
+	s.State = state
+	if previous == state {
+		return
+	}
+	switch state {
+	case "running":
+		if previous == "starting" && !s.startedAt.IsZero() {
+			s.startupTook = time.Since(s.startedAt)
+		}
+		s.parent.emit(Event{Type: EventReady, Server: s.Name, Message: reason})
+	case "stopped":
+		s.parent.emit(Event{Type: EventStopped, Server: s.Name, Message: reason})
+	case "crashed":
+		s.parent.emit(Event{Type: EventCrashed, Server: s.Name, Message: reason})
+	}
+}
+
+// applyRestart restarts a crashed or unresponsive server if its restart policy allows it,
+// waiting longer after every attempt
+func (s *Server) applyRestart() {
+	if s.RestartPolicy != "on-failure" {
+		return
+	}
+	if s.restarts >= s.RestartMax {
+		if !s.restartGaveUp {
+			s.restartGaveUp = true
+			s.Logger.Println("Not restarting server, it failed " + strconv.Itoa(s.restarts) + " times")
+		}
+		return
+	}
+	if s.restarts > 0 && time.Since(s.lastRestart) < restartBackoff<<s.restarts {
+		return
+	}
+	s.restarts++
+	s.lastRestart = time.Now()
+	s.Logger.Println("Restarting " + s.State + " server, attempt " + strconv.Itoa(s.restarts) + " of " + strconv.Itoa(s.RestartMax))
+	if s.State == "unresponsive" {
+		err := s.action("kill_server")
+		if err != nil {
+			s.Logger.Println("Can't kill server: " + err.Error())
+			return
+		}
+		if !s.waitStopped(killConfirmPeriod) {
+			s.Logger.Println("Can't kill server: it is still running")
+			return
+		}
+	}
+	// stay crashed until the start goes through, so a failed start is retried on the next check
+	s.State = "crashed"
+	err := s.Start("restart policy")
+	if err != nil {
+		s.Logger.Println("Restart failed: " + err.Error())
+	}
+}
+
+// armIdle starts the stop timer with the wake grace period if nobody is connected,
+// or with what is left of the pre-warm grace window if that's longer
+func (s *Server) armIdle() {
+	if !s.AutoOff || s.players > 0 {
+		return
+	}
+	grace := s.parent.WakeGrace
+	if until := time.Until(s.prewarmUntil); until > grace {
+		grace = until.Round(time.Second)
+	}
+	s.Logger.Println("Server is running with nobody on it, stopping it in " + grace.String() + " unless someone joins")
+	s.stopTimer.Reset(grace)
+}
+
+// checkAfk treats the server as empty once every connected player is AFK,
+// kicking them first if the server has the afk-kick option
+func (s *Server) checkAfk() {
+	if !s.allAfk() {
+		if s.afkArmed {
+			s.afkArmed = false
+			if s.players > 0 {
+				s.Logger.Println("Players are active again, not stopping server")
+				s.stopTimer.Stop()
+			}
+		}
+		return
+	}
+	if s.AfkKick {
+		for _, session := range s.Sessions() {
+			s.Logger.Println("Kicking AFK player " + session.Player)
+			err := s.SendCommand("kick " + session.Player + " You were AFK for too long")
+			if err != nil {
+				s.Logger.Println("Can't kick AFK player: " + err.Error())
+			}
+		}
+		return
+	}
+	if !s.afkArmed {
+		s.afkArmed = true
+		s.Logger.Println("All players are AFK, stopping server in " + strconv.Itoa(int(s.parent.StopTimeout)) + " minutes")
+		s.stopTimer.Reset(s.parent.StopTimeout * time.Minute)
+	}
+}
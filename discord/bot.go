@@ -0,0 +1,316 @@
+package discord
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Botond24/CraftyProxy/crafty"
+)
+
+const (
+	interactionCommand      = 2
+	interactionAutocomplete = 4
+
+	responseMessage      = 4
+	responseDeferred     = 5
+	responseAutocomplete = 8
+	// flagEphemeral makes a response only visible to the user who ran the command
+	flagEphemeral = 64
+
+	optionString = 3
+	// maxChoices is the most choices Discord allows for an option
+	maxChoices = 25
+)
+
+// The actions permissions can grant, named like the commands
+const (
+	ActionServers = "servers"
+	ActionStart   = "start"
+	ActionStop    = "stop"
+	ActionWho     = "who"
+)
+
+// Permission grants the members with a role the actions on the servers, "*" meaning all of them
+type Permission struct {
+	Role    string   `json:"role"`
+	Servers []string `json:"servers"`
+	Actions []string `json:"actions"`
+}
+
+// Config is the content of the Discord file. With Guild set, the commands are registered
+// for that guild only, which makes them available right away.
+type Config struct {
+	Guild       string       `json:"guild"`
+	Permissions []Permission `json:"permissions"`
+}
+
+// Bot answers slash commands on Discord
+type Bot struct {
+	crafty      *crafty.Crafty
+	token       string
+	config      Config
+	application string
+	logger      *log.Logger
+}
+
+type discordUser struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+type interaction struct {
+	ID    string `json:"id"`
+	Token string `json:"token"`
+	Type  int    `json:"type"`
+	Data  struct {
+		Name    string `json:"name"`
+		Options []struct {
+			Name    string `json:"name"`
+			Value   any    `json:"value"`
+			Focused bool   `json:"focused"`
+		} `json:"options"`
+	} `json:"data"`
+	Member *struct {
+		Roles []string    `json:"roles"`
+		User  discordUser `json:"user"`
+	} `json:"member"`
+}
+
+// New reads the Discord file and creates a bot with the token
+func New(c *crafty.Crafty, token string, path string) (*Bot, error) {
+	b := &Bot{crafty: c, token: token}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &b.config)
+	if err != nil {
+		return nil, err
+	}
+	actions := []string{ActionServers, ActionStart, ActionStop, ActionWho, "*"}
+	for _, permission := range b.config.Permissions {
+		for _, action := range permission.Actions {
+			if !slices.Contains(actions, action) {
+				return nil, errors.New("unknown action " + action + " for role " + permission.Role)
+			}
+		}
+	}
+	b.logger = log.New(os.Stdout, "discord: ", log.Ldate|log.Ltime)
+	return b, nil
+}
+
+// onReady registers the slash commands once the gateway tells the application id
+func (b *Bot) onReady(data json.RawMessage) error {
+	var ready struct {
+		User        discordUser `json:"user"`
+		Application struct {
+			ID string `json:"id"`
+		} `json:"application"`
+	}
+	err := json.Unmarshal(data, &ready)
+	if err != nil {
+		return err
+	}
+	b.application = ready.Application.ID
+	b.logger.Println("Connected as " + ready.User.Username)
+
+	// servers are autocompleted rather than fixed choices, as Crafty can add servers later
+	serverOption := []map[string]any{{
+		"type":         optionString,
+		"name":         "server",
+		"description":  "The server",
+		"required":     true,
+		"autocomplete": true,
+	}}
+	commands := []map[string]any{
+		{"name": ActionServers, "description": "List the servers with their state and players"},
+		{"name": ActionStart, "description": "Start a server", "options": serverOption},
+		{"name": ActionStop, "description": "Stop a server", "options": serverOption},
+		{"name": ActionWho, "description": "List the players on a server", "options": serverOption},
+	}
+	path := "/applications/" + b.application + "/commands"
+	if b.config.Guild != "" {
+		path = "/applications/" + b.application + "/guilds/" + b.config.Guild + "/commands"
+	}
+	return b.request("PUT", path, commands)
+}
+
+// allowed reports whether a member with the roles may run the action on the server
+func (b *Bot) allowed(roles []string, action string, server string) bool {
+	return slices.ContainsFunc(b.config.Permissions, func(permission Permission) bool {
+		return slices.Contains(roles, permission.Role) &&
+			(slices.Contains(permission.Actions, action) || slices.Contains(permission.Actions, "*")) &&
+			(slices.Contains(permission.Servers, "*") || slices.ContainsFunc(permission.Servers, func(name string) bool {
+				return strings.EqualFold(name, server)
+			}))
+	})
+}
+
+func (b *Bot) handle(i *interaction) {
+	if i.Type == interactionAutocomplete {
+		b.autocomplete(i)
+		return
+	}
+	if i.Type != interactionCommand {
+		return
+	}
+	if i.Member == nil {
+		b.respond(i, "Commands only work in a server, not in direct messages")
+		return
+	}
+	user := "discord:" + i.Member.User.Username
+	action := i.Data.Name
+	if action == ActionServers {
+		b.respond(i, b.listServers(i.Member.Roles))
+		return
+	}
+	var name string
+	for _, option := range i.Data.Options {
+		if option.Name == "server" {
+			name, _ = option.Value.(string)
+		}
+	}
+	s := b.crafty.Server(name)
+	if s == nil {
+		b.respond(i, "There's no server called "+name)
+		return
+	}
+	if !b.allowed(i.Member.Roles, action, s.Name) {
+		b.logger.Println(user + " isn't allowed to " + action + " " + s.Name)
+		b.respond(i, "You aren't allowed to "+action+" "+s.Name)
+		return
+	}
+	b.logger.Println(user + " used /" + action + " on " + s.Name)
+	switch action {
+	case ActionWho:
+		b.respond(i, who(s))
+	case ActionStart:
+		b.deferred(i, func() string {
+			if s.IsRunning() {
+				return s.Name + " is already running"
+			}
+			err := s.Start(user)
+			if err != nil {
+				return err.Error()
+			}
+			return "Starting " + s.Name + ", it's " + s.State
+		})
+	case ActionStop:
+		b.deferred(i, func() string {
+			if s.State == "stopped" || s.State == "crashed" {
+				return s.Name + " isn't running"
+			}
+			err := s.StopBy(user)
+			if err != nil {
+				return "Can't stop " + s.Name + ": " + err.Error()
+			}
+			return "Stopped " + s.Name
+		})
+	}
+}
+
+// autocomplete suggests the servers the member may use the command on, matching what they typed
+func (b *Bot) autocomplete(i *interaction) {
+	choices := []map[string]string{}
+	var typed string
+	for _, option := range i.Data.Options {
+		if option.Focused {
+			typed, _ = option.Value.(string)
+		}
+	}
+	for _, s := range b.crafty.Servers {
+		if len(choices) == maxChoices {
+			break
+		}
+		if i.Member == nil || !b.allowed(i.Member.Roles, i.Data.Name, s.Name) ||
+			!strings.Contains(strings.ToLower(s.Name), strings.ToLower(typed)) {
+			continue
+		}
+		choices = append(choices, map[string]string{"name": s.Name, "value": s.Name})
+	}
+	err := b.request("POST", "/interactions/"+i.ID+"/"+i.Token+"/callback", map[string]any{
+		"type": responseAutocomplete,
+		"data": map[string]any{"choices": choices},
+	})
+	if err != nil {
+		b.logger.Println("Can't autocomplete /" + i.Data.Name + ": " + err.Error())
+	}
+}
+
+// listServers describes the servers the member may list
+func (b *Bot) listServers(roles []string) string {
+	var lines []string
+	for _, s := range b.crafty.Servers {
+		if !b.allowed(roles, ActionServers, s.Name) {
+			continue
+		}
+		line := "**" + s.Name + "**: " + s.State
+		if s.State == "running" {
+			line += ", " + strconv.Itoa(s.Players()) + " players"
+		}
+		if eta := s.StartupETA(); eta > 0 {
+			line += ", ready in about " + eta.Round(time.Second).String()
+		}
+		if s.InMaintenance() {
+			line += " (maintenance)"
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return "There are no servers you may see"
+	}
+	return strings.Join(lines, "\n")
+}
+
+func who(s *crafty.Server) string {
+	sessions := s.Sessions()
+	if len(sessions) == 0 {
+		return "Nobody is on " + s.Name
+	}
+	lines := []string{strconv.Itoa(len(sessions)) + " on " + s.Name + ":"}
+	for _, session := range sessions {
+		line := "- " + session.Player + ", " + time.Since(session.Started).Round(time.Minute).String()
+		if idle := session.IdleFor(); idle >= time.Minute {
+			line += ", idle for " + idle.Round(time.Minute).String()
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// respond answers an interaction with a message only the user sees
+func (b *Bot) respond(i *interaction, content string) {
+	err := b.request("POST", "/interactions/"+i.ID+"/"+i.Token+"/callback", map[string]any{
+		"type": responseMessage,
+		"data": map[string]any{"content": content, "flags": flagEphemeral},
+	})
+	if err != nil {
+		b.logger.Println("Can't respond to /" + i.Data.Name + ": " + err.Error())
+	}
+}
+
+// deferred acknowledges an interaction right away, as starting or stopping takes longer
+// than Discord waits, and edits in the result of run, which only the user sees
+func (b *Bot) deferred(i *interaction, run func() string) {
+	err := b.request("POST", "/interactions/"+i.ID+"/"+i.Token+"/callback", map[string]any{
+		"type": responseDeferred,
+		"data": map[string]any{"flags": flagEphemeral},
+	})
+	if err != nil {
+		b.logger.Println("Can't respond to /" + i.Data.Name + ": " + err.Error())
+		return
+	}
+	content := run()
+	err = b.request("PATCH", "/webhooks/"+b.application+"/"+i.Token+"/messages/@original", map[string]any{
+		"content": content,
+	})
+	if err != nil {
+		b.logger.Println("Can't answer /" + i.Data.Name + ": " + err.Error())
+	}
+}
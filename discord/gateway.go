@@ -0,0 +1,201 @@
+// Package discord is a Discord bot with slash commands to list, start and stop servers.
+package discord
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	gatewayURL = "wss://gateway.discord.gg/?v=10&encoding=json"
+	apiURL     = "https://discord.com/api/v10"
+
+	opDispatch       = 0
+	opHeartbeat      = 1
+	opIdentify       = 2
+	opReconnect      = 7
+	opInvalidSession = 9
+	opHello          = 10
+
+	reconnectDelay    = 5 * time.Second
+	maxReconnectDelay = 5 * time.Minute
+	requestTimeout    = 15 * time.Second
+)
+
+var client = &http.Client{Timeout: requestTimeout}
+
+type payload struct {
+	Op       int             `json:"op"`
+	Data     json.RawMessage `json:"d,omitempty"`
+	Sequence *int            `json:"s,omitempty"`
+	Type     string          `json:"t,omitempty"`
+}
+
+// gateway is a connection to the Discord gateway, it only receives interactions
+type gateway struct {
+	conn     *websocket.Conn
+	writeMu  sync.Mutex
+	seqMu    sync.Mutex
+	sequence *int
+}
+
+func (g *gateway) send(op int, data any) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	g.writeMu.Lock()
+	defer g.writeMu.Unlock()
+	return g.conn.WriteJSON(payload{Op: op, Data: raw})
+}
+
+func (g *gateway) heartbeat() error {
+	g.seqMu.Lock()
+	sequence := g.sequence
+	g.seqMu.Unlock()
+	return g.send(opHeartbeat, sequence)
+}
+
+// Run keeps the bot connected to the gateway, reconnecting with a growing delay
+func (b *Bot) Run() {
+	delay := reconnectDelay
+	for {
+		ready, err := b.connect()
+		b.logger.Println("Gateway connection closed: " + err.Error())
+		if ready {
+			delay = reconnectDelay
+		}
+		time.Sleep(delay)
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+// connect identifies with the gateway and handles its events until the connection fails.
+// It reports whether the bot got ready on it.
+func (b *Bot) connect() (ready bool, err error) {
+	conn, _, err := websocket.DefaultDialer.Dial(gatewayURL, nil)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	g := &gateway{conn: conn}
+
+	var hello payload
+	err = conn.ReadJSON(&hello)
+	if err != nil {
+		return false, err
+	}
+	if hello.Op != opHello {
+		return false, errors.New("expected hello, got op " + strconv.Itoa(hello.Op))
+	}
+	var helloData struct {
+		Interval int `json:"heartbeat_interval"`
+	}
+	err = json.Unmarshal(hello.Data, &helloData)
+	if err != nil {
+		return false, err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(time.Duration(helloData.Interval) * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if g.heartbeat() != nil {
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	err = g.send(opIdentify, map[string]any{
+		"token":   b.token,
+		"intents": 0,
+		"properties": map[string]string{
+			"os":      "linux",
+			"browser": "craftyproxy",
+			"device":  "craftyproxy",
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	for {
+		var p payload
+		err = conn.ReadJSON(&p)
+		if err != nil {
+			return ready, err
+		}
+		if p.Sequence != nil {
+			g.seqMu.Lock()
+			g.sequence = p.Sequence
+			g.seqMu.Unlock()
+		}
+		switch p.Op {
+		case opHeartbeat:
+			err = g.heartbeat()
+			if err != nil {
+				return ready, err
+			}
+		case opReconnect:
+			return ready, errors.New("Discord asked to reconnect")
+		case opInvalidSession:
+			return ready, errors.New("invalid session")
+		case opDispatch:
+			switch p.Type {
+			case "READY":
+				ready = true
+				err = b.onReady(p.Data)
+				if err != nil {
+					b.logger.Println("Can't register commands: " + err.Error())
+				}
+			case "INTERACTION_CREATE":
+				var i interaction
+				err = json.Unmarshal(p.Data, &i)
+				if err != nil {
+					b.logger.Println("Can't read interaction: " + err.Error())
+					continue
+				}
+				go b.handle(&i)
+			}
+		}
+	}
+}
+
+// request calls the Discord REST API as the bot
+func (b *Bot) request(method string, path string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, apiURL+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bot "+b.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(resp.Body)
+		return errors.New(resp.Status + ": " + string(message))
+	}
+	return nil
+}
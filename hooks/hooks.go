@@ -0,0 +1,147 @@
+// Package hooks runs executables when the proxy emits events, like a server waking or a player joining.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Botond24/CraftyProxy/crafty"
+)
+
+const (
+	defaultTimeout    = 30 * time.Second
+	defaultConcurrent = 4
+	// maxOutput is how much of a hook's output is logged
+	maxOutput = 4096
+)
+
+// Hook runs Command for the listed events, of the listed servers or of all servers if there are none
+type Hook struct {
+	Events  []crafty.EventType `json:"events"`
+	Servers []string           `json:"servers"`
+	Command string             `json:"command"`
+	Args    []string           `json:"args"`
+	Timeout string             `json:"timeout"`
+
+	timeout time.Duration
+}
+
+// Hooks is the content of the hooks file
+type Hooks struct {
+	// MaxConcurrent is how many hooks may run at once, the others wait for a slot
+	MaxConcurrent int     `json:"max_concurrent"`
+	Hooks         []*Hook `json:"hooks"`
+
+	slots  chan struct{}
+	logger *log.Logger
+}
+
+// Load reads the hooks file, checking that the events are known and the commands exist
+func Load(path string) (*Hooks, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	h := &Hooks{}
+	err = json.Unmarshal(data, h)
+	if err != nil {
+		return nil, err
+	}
+	for i, hook := range h.Hooks {
+		name := "hook " + strconv.Itoa(i+1)
+		for _, event := range hook.Events {
+			if !slices.Contains(crafty.EventTypes, event) {
+				return nil, errors.New(name + ": unknown event " + string(event))
+			}
+		}
+		_, err = exec.LookPath(hook.Command)
+		if err != nil {
+			return nil, errors.New(name + ": " + err.Error())
+		}
+		hook.timeout = defaultTimeout
+		if hook.Timeout != "" {
+			hook.timeout, err = time.ParseDuration(hook.Timeout)
+			if err != nil {
+				return nil, errors.New(name + ": " + err.Error())
+			}
+		}
+	}
+	if h.MaxConcurrent <= 0 {
+		h.MaxConcurrent = defaultConcurrent
+	}
+	h.slots = make(chan struct{}, h.MaxConcurrent)
+	h.logger = log.New(os.Stdout, "hooks: ", log.Ldate|log.Ltime)
+	return h, nil
+}
+
+// Handle runs the hooks of an event, it's meant to be subscribed to the Crafty events
+func (h *Hooks) Handle(e crafty.Event) {
+	for _, hook := range h.Hooks {
+		if hook.matches(e) {
+			go h.run(hook, e)
+		}
+	}
+}
+
+func (hook *Hook) matches(e crafty.Event) bool {
+	if !slices.Contains(hook.Events, e.Type) {
+		return false
+	}
+	return len(hook.Servers) == 0 || slices.ContainsFunc(hook.Servers, func(name string) bool {
+		return strings.EqualFold(name, e.Server)
+	})
+}
+
+// run runs the hook with the event in env vars and as JSON on stdin, logging its output
+func (h *Hooks) run(hook *Hook, e crafty.Event) {
+	h.slots <- struct{}{}
+	defer func() { <-h.slots }()
+
+	input, err := json.Marshal(e)
+	if err != nil {
+		h.logger.Println("Can't encode event: " + err.Error())
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), hook.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, hook.Command, hook.Args...)
+	cmd.Env = append(os.Environ(),
+		"CRAFTY_EVENT="+string(e.Type),
+		"CRAFTY_SERVER="+e.Server,
+		"CRAFTY_PLAYER="+e.Player,
+		"CRAFTY_MESSAGE="+e.Message,
+		"CRAFTY_TIME="+e.Time.Format(time.RFC3339),
+	)
+	cmd.Stdin = bytes.NewReader(input)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	started := time.Now()
+	err = cmd.Run()
+	took := time.Since(started).Round(time.Millisecond).String()
+	name := hook.Command + " for " + string(e.Type) + " of " + e.Server
+	switch {
+	case ctx.Err() != nil:
+		h.logger.Println(name + " timed out after " + hook.timeout.String())
+	case err != nil:
+		h.logger.Println(name + " failed after " + took + ": " + err.Error())
+	default:
+		h.logger.Println(name + " finished in " + took)
+	}
+	if output.Len() > 0 {
+		out := output.String()
+		if len(out) > maxOutput {
+			out = out[:maxOutput] + "... (truncated)"
+		}
+		h.logger.Println(name + " output:\n" + strings.TrimRight(out, "\n"))
+	}
+}
@@ -1,27 +1,179 @@
 package main
 
 import (
-	"crypto/tls"
-	"net/http"
+	"image"
+	"image/png"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 
+	"github.com/Botond24/CraftyProxy/api"
 	"github.com/Botond24/CraftyProxy/crafty"
+	"github.com/Botond24/CraftyProxy/discord"
+	"github.com/Botond24/CraftyProxy/hooks"
+	"github.com/Botond24/CraftyProxy/notify"
 	"github.com/Botond24/CraftyProxy/proxy"
 )
 
 func main() {
-	http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	if len(os.Args) > 1 && os.Args[1] == "notify-test" {
+		notifyTest(os.Args[2:])
+		return
+	}
 	conf := getConfig()
+	proxy.KeepAlive = conf.KeepAlive
+	proxy.NoDelay = conf.NoDelay
+	proxy.QueuePriority = conf.Priority
+	proxy.QueueTimeout = conf.QueueTimeout
+	if conf.QuotaMessage != "" {
+		proxy.QuotaMessage = conf.QuotaMessage
+	}
+	if conf.MaintMessage != "" {
+		proxy.MaintenanceMessage = conf.MaintMessage
+	}
+	if conf.MaintIcon != "" {
+		proxy.MaintenanceIcon = loadIcon(conf.MaintIcon)
+	}
+	if conf.RulesFile != "" {
+		go proxy.WatchRules(conf.RulesFile)
+	}
 	c := crafty.New(conf.CraftyAddr, conf.Port, conf.Key, conf.Timeout)
+	c.DataDir = conf.DataDir
+	c.WakeGrace = conf.WakeGrace
+	c.AfkTimeout = conf.AfkTimeout
+	c.AfkBytes = conf.AfkBytes
+	c.StopWarnings = conf.StopWarnings
+	c.KillTimeout = conf.KillTimeout
+	c.StartupTimeout = conf.StartupTime
+	c.Admins = conf.Admins
+	c.RunningCaps = conf.RunningCaps
+	c.MaxHostCpu = conf.MaxHostCpu
+	c.MaxHostMemory = conf.MaxHostMem
+	c.AdmissionWait = conf.AdmitWait
+	c.PrewarmLead = conf.PrewarmLead
+	c.PrewarmGrace = conf.PrewarmGrace
+	c.PrewarmChance = conf.PrewarmProb
+	if conf.HooksFile != "" {
+		h, err := hooks.Load(conf.HooksFile)
+		if err != nil {
+			println("Can't load hooks: " + err.Error() + ", aborting...")
+			os.Exit(1)
+		}
+		c.Subscribe(h.Handle)
+	}
+	if conf.NotifyFile != "" {
+		n, err := notify.Load(conf.NotifyFile)
+		if err != nil {
+			println("Can't load notifications: " + err.Error() + ", aborting...")
+			os.Exit(1)
+		}
+		c.Subscribe(n.Handle)
+	}
 	c.GetServers()
+	if conf.GroupsFile != "" {
+		err := c.LoadGroups(conf.GroupsFile)
+		if err != nil {
+			println("Can't load groups: " + err.Error() + ", aborting...")
+			os.Exit(1)
+		}
+	}
+	if conf.PolicyFile != "" {
+		err := c.LoadPolicies(conf.PolicyFile)
+		if err != nil {
+			println("Can't load policies: " + err.Error() + ", aborting...")
+			os.Exit(1)
+		}
+	}
+	if conf.PlaytimeFile != "" {
+		err := c.LoadPlaytime(conf.PlaytimeFile)
+		if err != nil {
+			println("Can't load playtime limits: " + err.Error() + ", aborting...")
+			os.Exit(1)
+		}
+	}
+	if conf.ScheduleFile != "" {
+		err := c.LoadSchedules(conf.ScheduleFile)
+		if err != nil {
+			println("Can't load schedules: " + err.Error() + ", aborting...")
+			os.Exit(1)
+		}
+	}
+	if conf.Maintenance != "" {
+		c.LoadMaintenance(conf.Maintenance)
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				c.LoadMaintenance(conf.Maintenance)
+			}
+		}()
+	}
+	if conf.DiscordToken != "" {
+		bot, err := discord.New(c, conf.DiscordToken, conf.DiscordFile)
+		if err != nil {
+			println("Can't load Discord bot: " + err.Error() + ", aborting...")
+			os.Exit(1)
+		}
+		go bot.Run()
+	}
+	if conf.ApiAddr != "" {
+		go api.New(c, conf.ApiKey).Serve(conf.ApiAddr)
+	}
 	var wg sync.WaitGroup
 	go c.ListenWs(&wg, proxy.Handle)
 	for _, server := range c.Servers {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			proxy.Handle(&server, conf.Addr)
+			proxy.Handle(server, conf.Addr)
 		}()
 	}
 	wg.Wait()
 }
+
+// loadIcon reads a 64x64 PNG server icon, aborting if it can't be used
+func loadIcon(path string) image.Image {
+	f, err := os.Open(path)
+	if err != nil {
+		println("Can't open icon " + path + ", aborting...")
+		os.Exit(1)
+	}
+	defer f.Close()
+	icon, err := png.Decode(f)
+	if err != nil || !icon.Bounds().Size().Eq(image.Point{X: 64, Y: 64}) {
+		println("Icon " + path + " must be a 64x64 PNG, aborting...")
+		os.Exit(1)
+	}
+	return icon
+}
+
+// notifyTest sends a sample event, ready if none is given, to the targets in ProxyNotifyFile
+func notifyTest(args []string) {
+	path := os.Getenv("ProxyNotifyFile")
+	if path == "" {
+		println("ProxyNotifyFile is not set, aborting...")
+		os.Exit(1)
+	}
+	n, err := notify.Load(path)
+	if err != nil {
+		println("Can't load notifications: " + err.Error() + ", aborting...")
+		os.Exit(1)
+	}
+	eventType := crafty.EventReady
+	if len(args) > 0 {
+		eventType = crafty.EventType(args[0])
+	}
+	failed := false
+	for target, err := range n.Test(eventType) {
+		if err != nil {
+			println(target + ": " + err.Error())
+			failed = true
+		} else {
+			println(target + ": sent")
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
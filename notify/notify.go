@@ -0,0 +1,250 @@
+// Package notify sends templated event notifications to Discord and Slack webhooks,
+// ntfy and Gotify push, and email, routed per event.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Botond24/CraftyProxy/crafty"
+)
+
+const (
+	defaultRetries   = 3
+	defaultRate      = 20
+	retryBackoff     = 2 * time.Second
+	queueSize        = 100
+	defaultTitle     = "CraftyProxy: {{.Type}}{{if .Server}} on {{.Server}}{{end}}"
+	defaultMessage   = "{{if .Server}}{{.Server}}: {{end}}{{.Message}}"
+	templateFallback = "default"
+)
+
+// Target is where notifications are sent, Type being discord, slack, ntfy, gotify or smtp
+type Target struct {
+	Type string `json:"type"`
+	// URL is the webhook, the ntfy topic or the Gotify server
+	URL   string `json:"url"`
+	Token string `json:"token"`
+	// Host, Username, Password, From and To are for smtp, Host including the port
+	Host     string   `json:"host"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	// RatePerMinute is how many notifications the target gets at most, the rest wait
+	RatePerMinute int `json:"rate_per_minute"`
+
+	name   string
+	sender sender
+	queue  chan notification
+}
+
+// Route sends the listed events, of the listed servers or of all servers if there are none,
+// to the named targets
+type Route struct {
+	Events  []crafty.EventType `json:"events"`
+	Servers []string           `json:"servers"`
+	Targets []string           `json:"targets"`
+}
+
+// Notifier is the content of the notify file. Templates are keyed by event type
+// with "default" for the others, and get the event as data.
+type Notifier struct {
+	Targets   map[string]*Target `json:"targets"`
+	Routes    []Route            `json:"routes"`
+	Titles    map[string]string  `json:"titles"`
+	Templates map[string]string  `json:"templates"`
+	Retries   int                `json:"retries"`
+
+	titles    map[string]*template.Template
+	templates map[string]*template.Template
+	logger    *log.Logger
+}
+
+type notification struct {
+	title   string
+	message string
+	event   crafty.Event
+	done    chan error
+}
+
+// Load reads the notify file and starts a sender for every target
+func Load(path string) (*Notifier, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	n := &Notifier{Retries: defaultRetries}
+	err = json.Unmarshal(data, n)
+	if err != nil {
+		return nil, err
+	}
+	n.logger = log.New(os.Stdout, "notify: ", log.Ldate|log.Ltime)
+	n.titles, err = parseTemplates(n.Titles, defaultTitle)
+	if err != nil {
+		return nil, errors.New("titles: " + err.Error())
+	}
+	n.templates, err = parseTemplates(n.Templates, defaultMessage)
+	if err != nil {
+		return nil, errors.New("templates: " + err.Error())
+	}
+	for _, route := range n.Routes {
+		for _, event := range route.Events {
+			if !slices.Contains(crafty.EventTypes, event) {
+				return nil, errors.New("route for unknown event " + string(event))
+			}
+		}
+		for _, name := range route.Targets {
+			if _, ok := n.Targets[name]; !ok {
+				return nil, errors.New("route to unknown target " + name)
+			}
+		}
+	}
+	for name, target := range n.Targets {
+		target.name = name
+		target.sender, err = newSender(target)
+		if err != nil {
+			return nil, errors.New("target " + name + ": " + err.Error())
+		}
+		if target.RatePerMinute <= 0 {
+			target.RatePerMinute = defaultRate
+		}
+		target.queue = make(chan notification, queueSize)
+		go n.deliver(target)
+	}
+	return n, nil
+}
+
+func parseTemplates(sources map[string]string, fallback string) (map[string]*template.Template, error) {
+	templates := map[string]*template.Template{}
+	if _, ok := sources[templateFallback]; !ok {
+		templates[templateFallback] = template.Must(template.New(templateFallback).Parse(fallback))
+	}
+	for name, source := range sources {
+		if name != templateFallback && !slices.Contains(crafty.EventTypes, crafty.EventType(name)) {
+			return nil, errors.New("template for unknown event " + name)
+		}
+		t, err := template.New(name).Parse(source)
+		if err != nil {
+			return nil, err
+		}
+		templates[name] = t
+	}
+	return templates, nil
+}
+
+func render(templates map[string]*template.Template, e crafty.Event) (string, error) {
+	t, ok := templates[string(e.Type)]
+	if !ok {
+		t = templates[templateFallback]
+	}
+	var out bytes.Buffer
+	err := t.Execute(&out, e)
+	return out.String(), err
+}
+
+// Handle routes an event to its targets, it's meant to be subscribed to the Crafty events
+func (n *Notifier) Handle(e crafty.Event) {
+	for _, target := range n.targets(e) {
+		n.enqueue(target, e, nil)
+	}
+}
+
+// targets returns the targets an event is routed to, each once
+func (n *Notifier) targets(e crafty.Event) []*Target {
+	var targets []*Target
+	for _, route := range n.Routes {
+		if !slices.Contains(route.Events, e.Type) {
+			continue
+		}
+		if len(route.Servers) > 0 && !slices.ContainsFunc(route.Servers, func(name string) bool {
+			return strings.EqualFold(name, e.Server)
+		}) {
+			continue
+		}
+		for _, name := range route.Targets {
+			if target := n.Targets[name]; !slices.Contains(targets, target) {
+				targets = append(targets, target)
+			}
+		}
+	}
+	return targets
+}
+
+func (n *Notifier) enqueue(target *Target, e crafty.Event, done chan error) {
+	title, err := render(n.titles, e)
+	if err == nil {
+		var message string
+		message, err = render(n.templates, e)
+		if err == nil {
+			select {
+			case target.queue <- notification{title: title, message: message, event: e, done: done}:
+				return
+			default:
+				err = errors.New("the queue is full")
+			}
+		}
+	}
+	n.logger.Println("Can't notify " + target.name + " of " + string(e.Type) + ": " + err.Error())
+	if done != nil {
+		done <- err
+	}
+}
+
+// deliver sends the target's notifications one by one, retrying failures
+// and spacing them out to stay within the target's rate
+func (n *Notifier) deliver(target *Target) {
+	interval := time.Minute / time.Duration(target.RatePerMinute)
+	for notification := range target.queue {
+		var err error
+		for attempt := 0; attempt <= n.Retries; attempt++ {
+			if attempt > 0 {
+				time.Sleep(retryBackoff << (attempt - 1))
+			}
+			err = target.sender.send(notification.title, notification.message)
+			if err == nil {
+				break
+			}
+			n.logger.Println("Sending " + string(notification.event.Type) + " to " + target.name + " failed: " + err.Error())
+		}
+		if err != nil {
+			n.logger.Println("Gave up sending " + string(notification.event.Type) + " to " + target.name)
+		}
+		if notification.done != nil {
+			notification.done <- err
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Test sends a sample event to every target, or to the targets it's routed to if it's routed,
+// and waits for the results
+func (n *Notifier) Test(eventType crafty.EventType) map[string]error {
+	e := crafty.Event{
+		Type:    eventType,
+		Server:  "example",
+		Player:  "Steve",
+		Message: "This is a test notification for " + string(eventType),
+		Time:    time.Now(),
+	}
+	targets := n.targets(e)
+	if len(targets) == 0 {
+		for _, target := range n.Targets {
+			targets = append(targets, target)
+		}
+	}
+	results := map[string]error{}
+	done := make(chan error, 1)
+	for _, target := range targets {
+		n.enqueue(target, e, done)
+		results[target.name] = <-done
+	}
+	return results
+}
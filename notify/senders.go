@@ -0,0 +1,151 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const sendTimeout = 15 * time.Second
+
+type sender interface {
+	send(title string, message string) error
+}
+
+func newSender(target *Target) (sender, error) {
+	switch target.Type {
+	case "discord", "slack", "ntfy", "gotify":
+		if target.URL == "" {
+			return nil, errors.New(target.Type + " needs a url")
+		}
+		_, err := url.Parse(target.URL)
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch target.Type {
+	case "discord":
+		return &webhook{url: target.URL, field: "content", bold: "**"}, nil
+	case "slack":
+		return &webhook{url: target.URL, field: "text", bold: "*"}, nil
+	case "ntfy":
+		return &ntfy{url: target.URL, token: target.Token}, nil
+	case "gotify":
+		if target.Token == "" {
+			return nil, errors.New("gotify needs an application token")
+		}
+		return &gotify{url: strings.TrimSuffix(target.URL, "/"), token: target.Token}, nil
+	case "smtp":
+		if target.Host == "" || target.From == "" || len(target.To) == 0 {
+			return nil, errors.New("smtp needs a host, from and to")
+		}
+		return &email{host: target.Host, username: target.Username, password: target.Password,
+			from: target.From, to: target.To}, nil
+	}
+	return nil, errors.New("unknown type " + target.Type)
+}
+
+var client = &http.Client{Timeout: sendTimeout}
+
+// post sends a request, treating any status but 2xx as a failure
+func post(req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+func postJSON(url string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return post(req)
+}
+
+// webhook posts to a Discord or Slack incoming webhook, which take the text
+// in different fields and mark bold text differently
+type webhook struct {
+	url   string
+	field string
+	bold  string
+}
+
+func (w *webhook) send(title string, message string) error {
+	return postJSON(w.url, map[string]string{w.field: w.bold + title + w.bold + "\n" + message})
+}
+
+// ntfy publishes to the topic in the url
+type ntfy struct {
+	url   string
+	token string
+}
+
+func (n *ntfy) send(title string, message string) error {
+	req, err := http.NewRequest("POST", n.url, strings.NewReader(message))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", title)
+	if n.token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.token)
+	}
+	return post(req)
+}
+
+type gotify struct {
+	url   string
+	token string
+}
+
+func (g *gotify) send(title string, message string) error {
+	return postJSON(g.url+"/message?token="+url.QueryEscape(g.token), map[string]any{
+		"title":    title,
+		"message":  message,
+		"priority": 5,
+	})
+}
+
+type email struct {
+	host     string
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+func (e *email) send(title string, message string) error {
+	var auth smtp.Auth
+	if e.username != "" {
+		host, _, err := net.SplitHostPort(e.host)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", e.username, e.password, host)
+	}
+	body := "From: " + e.from + "\r\n" +
+		"To: " + strings.Join(e.to, ", ") + "\r\n" +
+		// the title holds server and player names, encoding it keeps line breaks out of the header
+		"Subject: " + mime.QEncoding.Encode("utf-8", title) + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + strings.ReplaceAll(message, "\n", "\r\n") + "\r\n"
+	return smtp.SendMail(e.host, auth, e.from, e.to, []byte(body))
+}
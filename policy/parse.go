@@ -0,0 +1,200 @@
+package policy
+
+import (
+	"errors"
+	"strconv"
+)
+
+// The grammar, lowest precedence first:
+//
+//	or      = and { ("or" | "||") and }
+//	and     = not { ("and" | "&&") not }
+//	not     = ("not" | "!") not | compare
+//	compare = primary [ op primary | "in" ["group"] primary | "between" primary "and" primary ]
+//	primary = number | string | "true" | "false" | name | "(" or ")" | "[" [ primary { "," primary } ] "]"
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it's one of the words or operators
+func (p *parser) accept(texts ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokenIdent && t.kind != tokenOp {
+		return "", false
+	}
+	for _, text := range texts {
+		if t.text == text {
+			p.pos++
+			return text, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) expect(text string) error {
+	if _, ok := p.accept(text); !ok {
+		return p.unexpected("expected " + strconv.Quote(text))
+	}
+	return nil
+}
+
+func (p *parser) unexpected(context string) error {
+	t := p.peek()
+	if t.kind == tokenEOF {
+		return errors.New(context + ", found the end of the expression")
+	}
+	return errors.New(context + ", found " + strconv.Quote(t.text) + " at " + strconv.Itoa(t.pos))
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("or", "||"); !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{and: false, left: left, right: right}
+	}
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("and", "&&"); !ok {
+			return left, nil
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{and: true, left: left, right: right}
+	}
+}
+
+func (p *parser) parseNot() (node, error) {
+	if _, ok := p.accept("not", "!"); ok {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &not{x: x}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *parser) parseCompare() (node, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if op, ok := p.accept("==", "!=", "<", "<=", ">", ">="); ok {
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return &compare{op: op, left: left, right: right}, nil
+	}
+	if _, ok := p.accept("in"); ok {
+		p.accept("group")
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return &in{left: left, right: right}, nil
+	}
+	if _, ok := p.accept("between"); ok {
+		low, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		err = p.expect("and")
+		if err != nil {
+			return nil, err
+		}
+		high, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return &between{x: left, low: low, high: high}, nil
+	}
+	return left, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokenNumber, tokenString:
+		p.next()
+		return &literal{value: t.value}, nil
+	case tokenIdent:
+		if isKeyword(t.text) {
+			return nil, p.unexpected("expected a value")
+		}
+		p.next()
+		switch t.text {
+		case "true":
+			return &literal{value: true}, nil
+		case "false":
+			return &literal{value: false}, nil
+		}
+		return &ident{name: t.text}, nil
+	}
+	if _, ok := p.accept("("); ok {
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return x, p.expect(")")
+	}
+	if _, ok := p.accept("["); ok {
+		l := &list{}
+		if _, ok := p.accept("]"); ok {
+			return l, nil
+		}
+		for {
+			item, err := p.parsePrimary()
+			if err != nil {
+				return nil, err
+			}
+			l.items = append(l.items, item)
+			if _, ok := p.accept("]"); ok {
+				return l, nil
+			}
+			err = p.expect(",")
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return nil, p.unexpected("expected a value")
+}
+
+func isKeyword(word string) bool {
+	switch word {
+	case "or", "and", "not", "in", "group", "between":
+		return true
+	}
+	return false
+}
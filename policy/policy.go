@@ -0,0 +1,404 @@
+// Package policy is a small expression language for the decisions the proxy makes,
+// like "player in group admins or hour between 16 and 22".
+package policy
+
+import (
+	"errors"
+	"net/netip"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Vars are the values an expression can use, by name. Values are strings,
+// float64 numbers, bools or []string lists.
+type Vars map[string]any
+
+// Program is a compiled expression that evaluates to a bool
+type Program struct {
+	source string
+	root   node
+	names  []string
+}
+
+// Compile parses an expression and checks it against the types of the sample vars,
+// so that unknown names and type errors are reported before it's ever evaluated
+func Compile(source string, sample Vars) (*Program, error) {
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, p.unexpected("expected the end of the expression")
+	}
+	k, err := root.check(sample)
+	if err != nil {
+		return nil, err
+	}
+	if k != kindBool {
+		return nil, errors.New("expression must be true or false, not a " + k.String())
+	}
+	program := &Program{source: source, root: root}
+	walk(root, func(n node) {
+		if id, ok := n.(*ident); ok && !slices.Contains(program.names, id.name) {
+			program.names = append(program.names, id.name)
+		}
+	})
+	sort.Strings(program.names)
+	return program, nil
+}
+
+// Eval evaluates the expression with the vars
+func (p *Program) Eval(vars Vars) (bool, error) {
+	v, err := p.root.eval(vars)
+	if err != nil {
+		return false, err
+	}
+	result, _ := v.(bool)
+	return result, nil
+}
+
+// Trace lists the values of the vars the expression uses, for logging decisions
+func (p *Program) Trace(vars Vars) string {
+	parts := make([]string, 0, len(p.names))
+	for _, name := range p.names {
+		parts = append(parts, name+"="+format(vars[name]))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (p *Program) String() string {
+	return p.source
+}
+
+type kind int
+
+const (
+	kindString kind = iota
+	kindNumber
+	kindBool
+	kindList
+)
+
+func (k kind) String() string {
+	return [...]string{"string", "number", "bool", "list"}[k]
+}
+
+func kindOf(v any) (kind, error) {
+	switch v.(type) {
+	case string:
+		return kindString, nil
+	case float64:
+		return kindNumber, nil
+	case bool:
+		return kindBool, nil
+	case []string:
+		return kindList, nil
+	}
+	return 0, errors.New("unsupported value " + format(v))
+}
+
+func format(v any) string {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case []string:
+		return "[" + strings.Join(v, ",") + "]"
+	}
+	return "?"
+}
+
+// plain formats a value without quoting strings
+func plain(v any) string {
+	if str, ok := v.(string); ok {
+		return str
+	}
+	return format(v)
+}
+
+type node interface {
+	eval(vars Vars) (any, error)
+	// check returns the kind of the node's value, or why it can't be evaluated
+	check(sample Vars) (kind, error)
+}
+
+func walk(n node, fn func(node)) {
+	fn(n)
+	switch n := n.(type) {
+	case *list:
+		for _, item := range n.items {
+			walk(item, fn)
+		}
+	case *not:
+		walk(n.x, fn)
+	case *logical:
+		walk(n.left, fn)
+		walk(n.right, fn)
+	case *compare:
+		walk(n.left, fn)
+		walk(n.right, fn)
+	case *in:
+		walk(n.left, fn)
+		walk(n.right, fn)
+	case *between:
+		walk(n.x, fn)
+		walk(n.low, fn)
+		walk(n.high, fn)
+	}
+}
+
+type literal struct {
+	value any
+}
+
+func (n *literal) eval(Vars) (any, error) {
+	return n.value, nil
+}
+
+func (n *literal) check(Vars) (kind, error) {
+	return kindOf(n.value)
+}
+
+type ident struct {
+	name string
+}
+
+func (n *ident) eval(vars Vars) (any, error) {
+	v, ok := vars[n.name]
+	if !ok {
+		return nil, errors.New("unknown name " + n.name)
+	}
+	return v, nil
+}
+
+func (n *ident) check(sample Vars) (kind, error) {
+	v, ok := sample[n.name]
+	if !ok {
+		return 0, errors.New("unknown name " + n.name)
+	}
+	return kindOf(v)
+}
+
+type list struct {
+	items []node
+}
+
+func (n *list) eval(vars Vars) (any, error) {
+	values := make([]string, 0, len(n.items))
+	for _, item := range n.items {
+		v, err := item.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, plain(v))
+	}
+	return values, nil
+}
+
+func (n *list) check(sample Vars) (kind, error) {
+	for _, item := range n.items {
+		k, err := item.check(sample)
+		if err != nil {
+			return 0, err
+		}
+		if k != kindString && k != kindNumber {
+			return 0, errors.New("lists can only hold strings and numbers")
+		}
+	}
+	return kindList, nil
+}
+
+type not struct {
+	x node
+}
+
+func (n *not) eval(vars Vars) (any, error) {
+	v, err := n.x.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	return !v.(bool), nil
+}
+
+func (n *not) check(sample Vars) (kind, error) {
+	return kindBool, expectKind(n.x, sample, kindBool, "not")
+}
+
+type logical struct {
+	and         bool
+	left, right node
+}
+
+func (n *logical) eval(vars Vars) (any, error) {
+	left, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	if left.(bool) != n.and {
+		return left, nil
+	}
+	return n.right.eval(vars)
+}
+
+func (n *logical) check(sample Vars) (kind, error) {
+	op := "or"
+	if n.and {
+		op = "and"
+	}
+	err := expectKind(n.left, sample, kindBool, op)
+	if err != nil {
+		return 0, err
+	}
+	return kindBool, expectKind(n.right, sample, kindBool, op)
+}
+
+type compare struct {
+	op          string
+	left, right node
+}
+
+func (n *compare) eval(vars Vars) (any, error) {
+	left, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	}
+	l, r := left.(float64), right.(float64)
+	switch n.op {
+	case "<":
+		return l < r, nil
+	case "<=":
+		return l <= r, nil
+	case ">":
+		return l > r, nil
+	}
+	return l >= r, nil
+}
+
+func (n *compare) check(sample Vars) (kind, error) {
+	left, err := n.left.check(sample)
+	if err != nil {
+		return 0, err
+	}
+	if n.op != "==" && n.op != "!=" {
+		left = kindNumber
+	} else if left == kindList {
+		return 0, errors.New(n.op + " can't compare lists, use in")
+	}
+	err = expectKind(n.left, sample, left, n.op)
+	if err != nil {
+		return 0, err
+	}
+	return kindBool, expectKind(n.right, sample, left, n.op)
+}
+
+// equal compares strings case-insensitively, as player names are
+func equal(a, b any) bool {
+	if a, ok := a.(string); ok {
+		return strings.EqualFold(a, b.(string))
+	}
+	return a == b
+}
+
+type in struct {
+	left, right node
+}
+
+// eval checks membership in a list, or for a string, whether it's an address in a CIDR
+// when the string is one, and a substring otherwise
+func (n *in) eval(vars Vars) (any, error) {
+	left, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	item := plain(left)
+	if values, ok := right.([]string); ok {
+		return slices.ContainsFunc(values, func(value string) bool {
+			return strings.EqualFold(value, item)
+		}), nil
+	}
+	str := right.(string)
+	if prefix, err := netip.ParsePrefix(str); err == nil {
+		addr, err := netip.ParseAddr(item)
+		return err == nil && prefix.Contains(addr.Unmap()), nil
+	}
+	return strings.Contains(strings.ToLower(str), strings.ToLower(item)), nil
+}
+
+func (n *in) check(sample Vars) (kind, error) {
+	left, err := n.left.check(sample)
+	if err != nil {
+		return 0, err
+	}
+	if left != kindString && left != kindNumber {
+		return 0, errors.New("in needs a string or number on the left, not a " + left.String())
+	}
+	right, err := n.right.check(sample)
+	if err != nil {
+		return 0, err
+	}
+	if right != kindList && right != kindString {
+		return 0, errors.New("in needs a list or string on the right, not a " + right.String())
+	}
+	return kindBool, nil
+}
+
+type between struct {
+	x, low, high node
+}
+
+func (n *between) eval(vars Vars) (any, error) {
+	values := make([]float64, 3)
+	for i, operand := range []node{n.x, n.low, n.high} {
+		v, err := operand.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v.(float64)
+	}
+	return values[0] >= values[1] && values[0] <= values[2], nil
+}
+
+func (n *between) check(sample Vars) (kind, error) {
+	for _, operand := range []node{n.x, n.low, n.high} {
+		err := expectKind(operand, sample, kindNumber, "between")
+		if err != nil {
+			return 0, err
+		}
+	}
+	return kindBool, nil
+}
+
+func expectKind(n node, sample Vars, want kind, op string) error {
+	k, err := n.check(sample)
+	if err != nil {
+		return err
+	}
+	if k != want {
+		return errors.New(op + " needs a " + want.String() + ", not a " + k.String())
+	}
+	return nil
+}
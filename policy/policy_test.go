@@ -0,0 +1,142 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+)
+
+func testVars() Vars {
+	return Vars{
+		"player":  "Steve",
+		"ip":      "192.168.1.20",
+		"hour":    float64(18),
+		"players": float64(3),
+		"running": true,
+		"admins":  []string{"Alex", "steve"},
+		"mods":    []string{"Herobrine"},
+	}
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		source string
+		want   bool
+	}{
+		// Precedence: not binds tighter than and, which binds tighter than or
+		{"true or false and false", true},
+		{"(true or false) and false", false},
+		{"false and false or true", true},
+		{"false and (false or true)", false},
+		{"not false and false", false},
+		{"not (false and false)", true},
+		{"!true || true && !false", true},
+		{"not not true", true},
+		{"players > 2 and hour < 12 or player == 'Steve'", true},
+		{"players > 2 and (hour < 12 or player == 'Alex')", false},
+		// between is inclusive, and its and isn't a logical and
+		{"hour between 16 and 22", true},
+		{"hour between 18 and 18", true},
+		{"hour between 19 and 22", false},
+		{"hour between 16 and 22 and players between 1 and 2", false},
+		{"not hour between 0 and 6", true},
+		// in with lists
+		{"player in admins", true},
+		{"player in group admins", true},
+		{"player in group mods", false},
+		{"player in ['alex', 'STEVE']", true},
+		{"players in [1, 2, 3]", true},
+		{"players in [1, 2]", false},
+		// in with CIDRs
+		{"ip in '192.168.1.0/24'", true},
+		{"ip in '10.0.0.0/8'", false},
+		{"ip in '192.168.1.20/32'", true},
+		{"player in '10.0.0.0/8'", false},
+		// in with substrings
+		{"'eve' in player", true},
+		{"'EVE' in player", true},
+		{"'bob' in player", false},
+		// == and != on strings ignore case
+		{"player == 'steve'", true},
+		{"player == 'STEVE'", true},
+		{"player != 'sTeVe'", false},
+		{"player == 'Alex'", false},
+		{"hour == 18", true},
+		{"hour != 18", false},
+		{"hour >= 18 and hour <= 18", true},
+		{"running == true", true},
+		{"running", true},
+	}
+	vars := testVars()
+	for _, test := range tests {
+		program, err := Compile(test.source, vars)
+		if err != nil {
+			t.Errorf("Compile(%q) failed: %v", test.source, err)
+			continue
+		}
+		got, err := program.Eval(vars)
+		if err != nil {
+			t.Errorf("Eval(%q) failed: %v", test.source, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Eval(%q) = %t, want %t", test.source, got, test.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		// Unknown names
+		{"foo == 1", "unknown name foo"},
+		{"player in group staff", "unknown name staff"},
+		{"hour between 1 and limit", "unknown name limit"},
+		// Type mismatches
+		{"hour", "expression must be true or false, not a number"},
+		{"player", "expression must be true or false, not a string"},
+		{"hour == 'six'", "== needs a number, not a string"},
+		{"player != 1", "!= needs a string, not a number"},
+		{"player > 3", "> needs a number, not a string"},
+		{"admins == admins", "== can't compare lists, use in"},
+		{"hour and true", "and needs a bool, not a number"},
+		{"player or true", "or needs a bool, not a string"},
+		{"not hour", "not needs a bool, not a number"},
+		{"player between 1 and 2", "between needs a number, not a string"},
+		{"admins in admins", "in needs a string or number on the left, not a list"},
+		{"player in hour", "in needs a list or string on the right, not a number"},
+		{"player in [admins]", "lists can only hold strings and numbers"},
+		// Syntax
+		{"hour between 1 or 2", "expected \"and\""},
+		{"(true", "expected \")\""},
+		{"true true", "expected the end of the expression"},
+		{"player == 'Steve", "unterminated string"},
+		{"hour == 1.2.3", "invalid number"},
+		{"hour # 1", "unexpected \"#\""},
+		{"player in", "expected a value"},
+	}
+	vars := testVars()
+	for _, test := range tests {
+		_, err := Compile(test.source, vars)
+		if err == nil {
+			t.Errorf("Compile(%q) succeeded, want an error with %q", test.source, test.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.want) {
+			t.Errorf("Compile(%q) = %q, want an error with %q", test.source, err, test.want)
+		}
+	}
+}
+
+func TestTrace(t *testing.T) {
+	vars := testVars()
+	program, err := Compile("player in admins and hour between 16 and 22", vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `admins=[Alex,steve] hour=18 player="Steve"`
+	if got := program.Trace(vars); got != want {
+		t.Errorf("Trace() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Botond24/CraftyProxy/crafty"
+)
+
+// RakNet offline message ids
+const (
+	raknetUnconnectedPing       = 0x01
+	raknetUnconnectedPingOpen   = 0x02
+	raknetOpenConnectionRequest = 0x05
+	raknetNoFreeConnections     = 0x14
+	raknetUnconnectedPong       = 0x1c
+)
+
+var raknetMagic = []byte{
+	0x00, 0xff, 0xff, 0x00, 0xfe, 0xfe, 0xfe, 0xfe,
+	0xfd, 0xfd, 0xfd, 0xfd, 0x12, 0x34, 0x56, 0x78,
+}
+
+// bedrockAdvert holds the parts of the Geyser advertisement that don't depend on the server state.
+// The defaults are replaced by the ones the backend reports once it has been seen running.
+type bedrockAdvert struct {
+	mu         sync.Mutex
+	protocol   string
+	version    string
+	maxPlayers string
+	gameMode   string
+}
+
+func handleBedrock(s *crafty.Server, addr string) {
+	conn, backend, err := listenUDP(s, addr, s.BedrockPort)
+	if err != nil {
+		s.Logger.Fatalf("Error starting bedrock proxy: %s\n", err)
+	}
+	defer conn.Close()
+	s.Logger.Println("Bedrock proxy started on port " + strconv.Itoa(s.ListenPort(s.BedrockPort)))
+
+	guid := rand.Int64()
+	advert := &bedrockAdvert{protocol: "766", version: "1.21.50", maxPlayers: "20", gameMode: "Survival"}
+	relay := newUDPRelay(s, conn, backend)
+	relay.onReply = advert.update
+	state := &stateCache{s: s}
+	buffer := make([]byte, udpBufferSize)
+	for {
+		n, client, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			s.Logger.Println("Error reading from bedrock connection: " + err.Error())
+			if s.State == "removed" {
+				return
+			}
+			continue
+		}
+		if n == 0 {
+			continue
+		}
+		if !addrAllowed(s, client) {
+			continue
+		}
+		if relay.has(client) || state.isRunning() {
+			relay.forward(client, buffer[:n])
+			continue
+		}
+		switch buffer[0] {
+		case raknetUnconnectedPing, raknetUnconnectedPingOpen:
+			if n < 33 {
+				continue
+			}
+			pong := bedrockPong(s, advert, buffer[1:9], guid)
+			_, err = conn.WriteToUDP(pong, client)
+			if err != nil {
+				s.Logger.Println("Error writing to bedrock connection: " + err.Error())
+			}
+		case raknetOpenConnectionRequest:
+			_, _ = wake(s, "bedrock client "+client.String(), "", "", client.String(), s.AutoOn)
+			reply := bytes.NewBuffer([]byte{raknetNoFreeConnections})
+			reply.Write(raknetMagic)
+			_ = binary.Write(reply, binary.BigEndian, guid)
+			_, _ = conn.WriteToUDP(reply.Bytes(), client)
+		}
+	}
+}
+
+// bedrockPong builds an unconnected pong advertising the sleeping server
+func bedrockPong(s *crafty.Server, advert *bedrockAdvert, pingTime []byte, guid int64) []byte {
+	advert.mu.Lock()
+	motd := strings.Join([]string{
+		"MCPE",
+		strings.ReplaceAll(s.Name, ";", ""),
+		advert.protocol,
+		advert.version,
+		"0",
+		advert.maxPlayers,
+		strconv.FormatInt(guid, 10),
+		strings.ReplaceAll(bedrockStatus(s), ";", ","),
+		advert.gameMode,
+		"1",
+		strconv.Itoa(s.ListenPort(s.BedrockPort)),
+		strconv.Itoa(s.ListenPort(s.BedrockPort)),
+	}, ";") + ";"
+	advert.mu.Unlock()
+
+	pong := bytes.NewBuffer([]byte{raknetUnconnectedPong})
+	pong.Write(pingTime)
+	_ = binary.Write(pong, binary.BigEndian, guid)
+	pong.Write(raknetMagic)
+	_ = binary.Write(pong, binary.BigEndian, uint16(len(motd)))
+	pong.WriteString(motd)
+	return pong.Bytes()
+}
+
+// bedrockStatus is the status message for Bedrock players, who can't confirm or vote
+// for a start, as they aren't identified before they connect
+func bedrockStatus(s *crafty.Server) string {
+	if s.AutoOn && needsConfirmation(s) && (s.State == "stopped" || s.State == "crashed") &&
+		!s.InMaintenance() && !s.OverQuota() {
+		return messageConfirm
+	}
+	return statusMessage(s)
+}
+
+// update remembers the version information from pongs sent by the running backend
+func (a *bedrockAdvert) update(data []byte) {
+	if len(data) < 35 || data[0] != raknetUnconnectedPong {
+		return
+	}
+	length := int(binary.BigEndian.Uint16(data[33:35]))
+	if len(data) < 35+length {
+		return
+	}
+	fields := strings.Split(string(data[35:35+length]), ";")
+	if len(fields) < 9 {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.protocol = fields[2]
+	a.version = fields[3]
+	a.maxPlayers = fields[5]
+	a.gameMode = fields[8]
+}
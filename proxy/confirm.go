@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Botond24/CraftyProxy/crafty"
+)
+
+// startPolicy tracks the confirmations and votes to start a server
+type startPolicy struct {
+	mu sync.Mutex
+	// confirms is when the confirmation of each player runs out
+	confirms map[string]time.Time
+	// votes is when each player voted
+	votes map[string]time.Time
+}
+
+var (
+	policiesMu sync.Mutex
+	policies   = map[*crafty.Server]*startPolicy{}
+)
+
+func policyFor(s *crafty.Server) *startPolicy {
+	policiesMu.Lock()
+	defer policiesMu.Unlock()
+	p, ok := policies[s]
+	if !ok {
+		p = &startPolicy{confirms: map[string]time.Time{}, votes: map[string]time.Time{}}
+		policies[s] = p
+	}
+	return p
+}
+
+// needsConfirmation reports whether the server only starts once a player confirms it or a vote passes
+func needsConfirmation(s *crafty.Server) bool {
+	return s.StartConfirm > 0 || s.StartVotes > 1
+}
+
+// confirmStart applies the server's start policies to a join attempt. It returns an empty
+// string if the server may be started, otherwise the confirmation or vote status for the player.
+func confirmStart(s *crafty.Server, name string) string {
+	if !needsConfirmation(s) || s.IsAdmin(name) {
+		return ""
+	}
+	if s.State != "stopped" && s.State != "crashed" {
+		return ""
+	}
+	p := policyFor(s)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	key := strings.ToLower(name)
+	if s.StartConfirm > 0 {
+		for player, deadline := range p.confirms {
+			if now.After(deadline) {
+				delete(p.confirms, player)
+			}
+		}
+		if _, ok := p.confirms[key]; !ok {
+			p.confirms[key] = now.Add(s.StartConfirm)
+			s.Logger.Println(name + " needs to rejoin to confirm starting the server")
+			return "Join again within " + s.StartConfirm.String() + " to confirm starting the server"
+		}
+		delete(p.confirms, key)
+	}
+	if s.StartVotes > 1 {
+		p.pruneVotes(s, now)
+		if _, ok := p.votes[key]; !ok {
+			p.votes[key] = now
+		}
+		if len(p.votes) < s.StartVotes {
+			s.Logger.Println(name + " voted to start the server, " + strconv.Itoa(len(p.votes)) + "/" + strconv.Itoa(s.StartVotes))
+			return "You voted to start the server\n" + p.voteStatus(s, now)
+		}
+		clear(p.votes)
+		s.Logger.Println("The vote to start the server passed")
+	}
+	return ""
+}
+
+func (p *startPolicy) pruneVotes(s *crafty.Server, now time.Time) {
+	for player, voted := range p.votes {
+		if now.Sub(voted) > s.VoteWindow {
+			delete(p.votes, player)
+		}
+	}
+}
+
+// voteStatus describes the running vote, the caller holds the lock
+func (p *startPolicy) voteStatus(s *crafty.Server, now time.Time) string {
+	var first time.Time
+	for _, voted := range p.votes {
+		if first.IsZero() || voted.Before(first) {
+			first = voted
+		}
+	}
+	closes := first.Add(s.VoteWindow).Sub(now).Round(time.Second)
+	return strconv.Itoa(len(p.votes)) + "/" + strconv.Itoa(s.StartVotes) + " players want to start it, " +
+		strconv.Itoa(s.StartVotes-len(p.votes)) + " more need to join in the next " + closes.String()
+}
+
+// pendingVotes describes the running vote to start the server, empty if there's none
+func pendingVotes(s *crafty.Server) string {
+	if s.StartVotes <= 1 {
+		return ""
+	}
+	p := policyFor(s)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	p.pruneVotes(s, now)
+	if len(p.votes) == 0 {
+		return ""
+	}
+	return p.voteStatus(s, now)
+}
@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"time"
+
+	mcnet "github.com/Tnze/go-mc/net"
+	pk "github.com/Tnze/go-mc/net/packet"
+	"github.com/google/uuid"
+)
+
+const (
+	intentStatus = 1
+	intentLogin  = 2
+
+	// protocol of 1.20.2, the first version always sending the uuid in Login Start
+	protocolLoginUUID = 764
+	peekTimeout       = 10 * time.Second
+)
+
+type loginStart struct {
+	protocol int32
+	intent   int32
+	name     string
+	id       uuid.UUID
+}
+
+// peekLogin reads the handshake and, for logins, the Login Start packet from a new connection.
+// Everything read is returned in raw, so it can be replayed to the backend even if parsing failed.
+func peekLogin(conn net.Conn) (login loginStart, raw []byte, err error) {
+	var buffer bytes.Buffer
+	c := &mcnet.Conn{
+		Socket: conn,
+		Reader: io.TeeReader(conn, &buffer),
+		Writer: conn,
+	}
+	c.SetThreshold(-1)
+	_ = conn.SetReadDeadline(time.Now().Add(peekTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	var (
+		p                pk.Packet
+		protocol, intent pk.VarInt
+		serverAddress    pk.String
+		serverPort       pk.UnsignedShort
+		name             pk.String
+		id               pk.UUID
+	)
+	err = c.ReadPacket(&p)
+	if err != nil {
+		return login, buffer.Bytes(), err
+	}
+	err = p.Scan(&protocol, &serverAddress, &serverPort, &intent)
+	if err != nil {
+		return login, buffer.Bytes(), err
+	}
+	login.protocol = int32(protocol)
+	login.intent = int32(intent)
+	if login.intent != intentLogin {
+		return login, buffer.Bytes(), nil
+	}
+	err = c.ReadPacket(&p)
+	if err != nil {
+		return login, buffer.Bytes(), err
+	}
+	if login.protocol >= protocolLoginUUID {
+		err = p.Scan(&name, &id)
+	} else {
+		err = p.Scan(&name)
+	}
+	login.name = string(name)
+	login.id = uuid.UUID(id)
+	return login, buffer.Bytes(), err
+}
+
+// validName reports whether a player name is one Minecraft allows, 1 to 16 letters,
+// digits and underscores. Names end up in console commands, so others are refused.
+func validName(name string) bool {
+	if len(name) == 0 || len(name) > 16 {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
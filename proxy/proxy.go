@@ -1,7 +1,9 @@
 package proxy
 
 import (
+	"bytes"
 	"errors"
+	"image"
 	"io"
 	"log"
 	"net"
@@ -20,6 +22,14 @@ import (
 var (
 	messageOn  = "The server is starting, please try again in a minute."
 	messageOff = "The server is stopped, please ask the owner to start it up"
+	// messageConfirm is for clients that can't take part in the start confirmations
+	messageConfirm = "The server is stopped, join it from Java Edition to start it"
+	// QuotaMessage is shown while a server's monthly bandwidth quota is used up
+	QuotaMessage = "The server used up its bandwidth for this month"
+	// MaintenanceMessage is shown while a server is in maintenance mode
+	MaintenanceMessage = "The server is under maintenance, please come back later"
+	// MaintenanceIcon replaces the server icon during maintenance if set, it must be 64x64
+	MaintenanceIcon image.Image
 )
 
 func Handle(s *crafty.Server, addr string) {
@@ -29,60 +39,115 @@ func Handle(s *crafty.Server, addr string) {
 	}
 	s.Logger.Println("TCP Proxy server started on port " + strconv.Itoa(int(s.OutPort)))
 	s.Handled = true
-	var udp *net.UDPConn = nil
+	if s.BedrockPort != 0 {
+		go handleBedrock(s, addr)
+	}
+	if s.QueryPort != 0 {
+		go handleQuery(s, addr)
+	}
+	if s.RconPort != 0 {
+		go handleRcon(s, addr)
+	}
 	if s.VoicePort != 0 {
-		var udpAddr *net.UDPAddr = nil
-		if s.VoicePort == -1 {
-			udpAddr, err = net.ResolveUDPAddr("udp", addr+":"+strconv.Itoa(int(s.OutPort)))
-			if err != nil {
-				log.Fatalf("Error getting udp address: %s\n", err)
-			}
-		} else {
-			udpAddr, err = net.ResolveUDPAddr("udp", addr+":"+strconv.Itoa(s.VoicePort))
-			if err != nil {
-				log.Fatalf("Error getting udp address: %s\n", err)
-			}
-		}
-		udp, err = net.ListenUDP("udp", udpAddr)
-		if err != nil {
-			log.Fatalf("Error starting proxy server: %s\n", err)
-		}
-
+		go handleVoice(s, addr)
 	}
 	for {
-		if udp != nil && s.VoicePort != 0 {
-			go handleUDP(s, udp)
-		}
 		conn, err := listen.Accept()
 		if err != nil {
 			log.Println("Error accepting connection: " + err.Error())
 			continue
 		}
+		if !addrAllowed(s, conn.RemoteAddr()) {
+			s.Logger.Println("Denied connection from " + conn.RemoteAddr().String())
+			conn.Close()
+			continue
+		}
 		go handleConnection(s, conn)
 		if s.State == "removed" { // server was removed
 			break
 		}
 	}
-	if udp != nil {
-		udp.Close()
-	}
 	listen.Close()
 	s.Remove()
 }
 
-func handleUDP(s *crafty.Server, udp *net.UDPConn) {
-	return //TODO: figure out the udp proxy
-	/* if s.IsRunning() {
-		forwardUDP(s, udp)
-	}*/
+// handleVoice relays the voice chat UDP port to the backend while the server runs,
+// counting the traffic like the game connections
+func handleVoice(s *crafty.Server, addr string) {
+	conn, backend, err := listenUDP(s, addr, s.VoicePort)
+	if err != nil {
+		s.Logger.Fatalf("Error starting voice proxy: %s\n", err)
+	}
+	defer conn.Close()
+	s.Logger.Println("Voice proxy started on port " + strconv.Itoa(s.ListenPort(s.VoicePort)))
+	relay := newUDPRelay(s, conn, backend)
+	state := &stateCache{s: s}
+	buffer := make([]byte, udpBufferSize)
+	for {
+		n, client, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			s.Logger.Println("Error reading from voice connection: " + err.Error())
+			if s.State == "removed" {
+				return
+			}
+			continue
+		}
+		if !addrAllowed(s, client) {
+			continue
+		}
+		if relay.has(client) || state.isRunning() {
+			relay.forward(client, buffer[:n])
+		}
+	}
 }
 
 func handleConnection(s *crafty.Server, conn net.Conn) {
+	login, raw, err := peekLogin(conn)
+	if err != nil {
+		s.Logger.Println("Can't read login from " + conn.RemoteAddr().String() + ": " + err.Error())
+	}
+	if login.intent == intentLogin {
+		if !validName(login.name) {
+			s.Logger.Println("Denied invalid player name " + strconv.Quote(login.name) + " from " + conn.RemoteAddr().String())
+			_ = disconnect(loginConn(conn), "Invalid player name")
+			conn.Close()
+			return
+		}
+		if ban := findBan(s, login.name, login.id.String()); ban != nil {
+			s.Logger.Println("Denied banned player " + login.name + " from " + conn.RemoteAddr().String())
+			_ = disconnect(loginConn(conn), ban.message())
+			conn.Close()
+			return
+		}
+	}
+	// only admins get through while in maintenance, everyone else gets the maintenance reply
+	if s.InMaintenance() && !(login.intent == intentLogin && s.IsAdmin(login.name)) {
+		startingReply(s, conn, raw)
+		return
+	}
 	if s.IsRunning() {
-		forward(s, conn)
+		if login.intent == intentLogin {
+			if !s.Decide(crafty.DecisionAdmit, true, login.name, login.id.String(), conn.RemoteAddr().String()) {
+				_ = disconnect(loginConn(conn), "You are not allowed to join this server")
+				conn.Close()
+				return
+			}
+			if denial := s.PlaytimeDenial(login.name, login.id.String()); denial != "" {
+				s.Logger.Println("Denied " + login.name + ", their playtime is used up")
+				_ = disconnect(loginConn(conn), denial)
+				conn.Close()
+				return
+			}
+			if !admit(s, conn, login) {
+				conn.Close()
+				return
+			}
+			defer s.DecrementPlayers()
+		}
+		forward(s, conn, login, raw)
 		return
 	}
-	startingReply(s, conn)
+	startingReply(s, conn, raw)
 }
 
 type LoginDenier struct {
@@ -103,24 +168,40 @@ func (c *LoginDenier) AcceptLogin(conn *mcnet.Conn, protocol int32) (name string
 		return
 	}
 	if c.Server != nil {
-		if c.AutoOn {
-			c.Start(name)
-			_ = conn.WritePacket(pk.Marshal(
-				packetid.ClientboundLoginLoginDisconnect,
-				chat.JsonMessage{Text: messageOn},
-			))
-			err = errors.New(messageOn)
+		if c.InMaintenance() && !c.IsAdmin(name) {
+			err = disconnect(conn, MaintenanceMessage)
+		} else if denial := c.PlaytimeDenial(name, id.String()); denial != "" {
+			err = disconnect(conn, denial)
+		} else if c.OverQuota() {
+			err = disconnect(conn, QuotaMessage)
 		} else {
-			_ = conn.WritePacket(pk.Marshal(
-				packetid.ClientboundLoginLoginDisconnect,
-				chat.JsonMessage{Text: messageOff},
-			))
-			err = errors.New(messageOff)
+			message, _ := wake(c.Server, name, name, id.String(), conn.Socket.RemoteAddr().String(), c.AutoOn || c.InMaintenance())
+			err = disconnect(conn, message)
 		}
 	}
 	return
 }
 
+// loginConn wraps a raw connection in the login state, before compression is set
+func loginConn(conn net.Conn) *mcnet.Conn {
+	c := &mcnet.Conn{
+		Socket: conn,
+		Reader: conn,
+		Writer: conn,
+	}
+	c.SetThreshold(-1)
+	return c
+}
+
+// disconnect sends a Login Disconnect with the message, returning the message as error
+func disconnect(conn *mcnet.Conn, message string) error {
+	_ = conn.WritePacket(pk.Marshal(
+		packetid.ClientboundLoginLoginDisconnect,
+		chat.JsonMessage{Text: message},
+	))
+	return errors.New(message)
+}
+
 type ServerInfo struct {
 	*server.PlayerList
 	*server.PingInfo
@@ -130,15 +211,49 @@ func (s ServerInfo) Protocol(clientProtocol int32) int {
 	return int(clientProtocol)
 }
 
-func startingReply(s *crafty.Server, conn net.Conn) {
-	playerList := server.NewPlayerList(1)
-	pingInfo := server.NewPingInfo(s.Name, 0, chat.Text(messageOff), nil)
-	if s.AutoOn {
-		pingInfo = server.NewPingInfo(s.Name, 0, chat.Text("The server is stopped, you can start it by joining"), nil)
+// statusMessage is the MOTD shown for a server that isn't running
+func statusMessage(s *crafty.Server) string {
+	if s.InMaintenance() {
+		return MaintenanceMessage
+	}
+	if s.OverQuota() {
+		return QuotaMessage
 	}
 	if s.State == "starting" {
-		pingInfo = server.NewPingInfo(s.Name, 0, chat.Text("The server is starting, please wait"), nil)
+		return "The server is starting, please wait"
+	}
+	if s.State == "crashed" {
+		if s.AutoOn {
+			return "The server crashed, you can try to start it again by joining"
+		}
+		return "The server crashed, please ask the owner to look at it"
+	}
+	if s.State == "unresponsive" {
+		return "The server is not responding, please try again later"
+	}
+	if s.State == "backing_up" {
+		return "The server is backing up, please try again in a few minutes"
+	}
+	if s.State == "stopping" {
+		return "The server is stopping, please wait"
 	}
+	if s.AutoOn {
+		if votes := pendingVotes(s); votes != "" {
+			return "The server is stopped, " + votes
+		}
+		return "The server is stopped, you can start it by joining"
+	}
+	return messageOff
+}
+
+// startingReply answers a connection with the sleeping server's status, replaying what was already read
+func startingReply(s *crafty.Server, conn net.Conn, raw []byte) {
+	playerList := server.NewPlayerList(1)
+	var icon image.Image
+	if s.InMaintenance() {
+		icon = MaintenanceIcon
+	}
+	pingInfo := server.NewPingInfo(s.Name, 0, chat.Text(statusMessage(s)), icon)
 	serverInfo := ServerInfo{
 		PlayerList: playerList,
 		PingInfo:   pingInfo,
@@ -155,14 +270,16 @@ func startingReply(s *crafty.Server, conn net.Conn) {
 	}
 	c := &mcnet.Conn{
 		Socket: conn,
-		Reader: conn,
+		Reader: io.MultiReader(bytes.NewReader(raw), conn),
 		Writer: conn,
 	}
 	c.SetThreshold(-1)
 	srv.AcceptConn(c)
 }
 
-func forward(s *crafty.Server, conn net.Conn) {
+// forward proxies a connection to the running backend, replaying the already read handshake and login
+func forward(s *crafty.Server, conn net.Conn, login loginStart, raw []byte) {
+	defer conn.Close()
 	serverConn, err := net.Dial("tcp", s.Address+":"+strconv.Itoa(int(s.InPort)))
 	if err != nil {
 		s.Logger.Println("Error connecting to server: " + err.Error())
@@ -170,18 +287,42 @@ func forward(s *crafty.Server, conn net.Conn) {
 	}
 	s.Logger.Println("Connected to server")
 	defer serverConn.Close()
-	defer conn.Close()
+	tuneConn(conn)
+	tuneConn(serverConn)
+	_, err = serverConn.Write(raw)
+	if err != nil {
+		s.Logger.Println("Error copying data to server: " + err.Error())
+		return
+	}
+
+	var session *crafty.Session
+	if login.intent == intentLogin {
+		s.Logger.Println("User Connected: " + login.name)
+		session = s.OpenSession(login.name, login.id.String(), conn)
+		defer s.CloseSession(session)
+	}
+	s.AddTraffic(session, int64(len(raw)), 0)
+
+	done := make(chan struct{})
 	go func() {
-		s.Logger.Println("User Connected")
-		s.IncrementPlayers()
-		defer s.DecrementPlayers()
-		io.Copy(conn, serverConn)
+		defer close(done)
+		_, err := pipe(conn, serverConn, true, func(n int64) {
+			s.AddTraffic(session, 0, n)
+		})
+		if err != nil {
+			s.Logger.Println("Error copying data to client: " + err.Error())
+			conn.Close()
+		}
 	}()
-	_, err = io.Copy(serverConn, conn)
+	// client traffic is small, reading it with a buffer lets AFK detection see every packet
+	_, err = pipe(serverConn, conn, false, func(n int64) {
+		s.AddTraffic(session, n, 0)
+	})
 	if err != nil {
-		s.Logger.Println("Error copying data: " + err.Error())
+		s.Logger.Println("Error copying data to server: " + err.Error())
+		serverConn.Close()
 	}
-	return
+	<-done
 }
 
 func forwardUDP(s *crafty.Server, udp *net.UDPConn) {
@@ -0,0 +1,187 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Botond24/CraftyProxy/crafty"
+)
+
+// GameSpy4 query packet types
+const (
+	queryTypeStat      = 0x00
+	queryTypeHandshake = 0x09
+	queryTokenLifetime = 30 * time.Second
+)
+
+var (
+	queryMagic       = []byte{0xfe, 0xfd}
+	queryFullPadding = []byte("splitnum\x00\x80\x00")
+	queryPlayerKey   = []byte("\x01player_\x00\x00")
+)
+
+// queryKeys is the order vanilla sends the full stat keys in
+var queryKeys = []string{"hostname", "gametype", "game_id", "version", "plugins", "map", "numplayers", "maxplayers", "hostport", "hostip"}
+
+// queryCache keeps the last stat the running backend answered with,
+// so it can be served while the server sleeps
+type queryCache struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+type queryToken struct {
+	token  int32
+	issued time.Time
+}
+
+func handleQuery(s *crafty.Server, addr string) {
+	conn, backend, err := listenUDP(s, addr, s.QueryPort)
+	if err != nil {
+		s.Logger.Fatalf("Error starting query proxy: %s\n", err)
+	}
+	defer conn.Close()
+	port := s.ListenPort(s.QueryPort)
+	s.Logger.Println("Query proxy started on port " + strconv.Itoa(port))
+
+	cache := &queryCache{values: map[string]string{
+		"gametype":   "SMP",
+		"game_id":    "MINECRAFT",
+		"version":    "",
+		"plugins":    "",
+		"maxplayers": "20",
+		"hostip":     "0.0.0.0",
+	}}
+	relay := newUDPRelay(s, conn, backend)
+	relay.onReply = cache.update
+	state := &stateCache{s: s}
+	tokens := map[string]queryToken{}
+	buffer := make([]byte, udpBufferSize)
+	for {
+		n, client, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			s.Logger.Println("Error reading from query connection: " + err.Error())
+			if s.State == "removed" {
+				return
+			}
+			continue
+		}
+		if n < 7 || !bytes.Equal(buffer[:2], queryMagic) || !addrAllowed(s, client) {
+			continue
+		}
+		if relay.has(client) || state.isRunning() {
+			relay.forward(client, buffer[:n])
+			continue
+		}
+		session := buffer[3:7]
+		var reply []byte
+		switch buffer[2] {
+		case queryTypeHandshake:
+			token := queryToken{token: rand.Int32(), issued: time.Now()}
+			tokens[client.IP.String()] = token
+			reply = append([]byte{queryTypeHandshake}, session...)
+			reply = append(reply, strconv.Itoa(int(token.token))...)
+			reply = append(reply, 0)
+		case queryTypeStat:
+			if n < 11 {
+				continue
+			}
+			token, ok := tokens[client.IP.String()]
+			if !ok || time.Since(token.issued) > queryTokenLifetime ||
+				int32(binary.BigEndian.Uint32(buffer[7:11])) != token.token {
+				continue
+			}
+			reply = cache.stat(s, session, n >= 15)
+		default:
+			continue
+		}
+		_, err = conn.WriteToUDP(reply, client)
+		if err != nil {
+			s.Logger.Println("Error writing to query connection: " + err.Error())
+		}
+		for ip, token := range tokens {
+			if time.Since(token.issued) > queryTokenLifetime {
+				delete(tokens, ip)
+			}
+		}
+	}
+}
+
+// stat answers a basic or full stat request from the cached values, showing the sleeping state
+func (c *queryCache) stat(s *crafty.Server, session []byte, full bool) []byte {
+	c.mu.Lock()
+	values := make(map[string]string, len(c.values))
+	for k, v := range c.values {
+		values[k] = v
+	}
+	c.mu.Unlock()
+	values["hostname"] = statusMessage(s)
+	values["map"] = "sleeping"
+	if s.State == "starting" {
+		values["map"] = "starting"
+	}
+	values["numplayers"] = "0"
+	values["hostport"] = strconv.Itoa(int(s.OutPort))
+
+	reply := bytes.NewBuffer([]byte{queryTypeStat})
+	reply.Write(session)
+	if !full {
+		for _, key := range []string{"hostname", "gametype", "map", "numplayers", "maxplayers"} {
+			reply.WriteString(values[key])
+			reply.WriteByte(0)
+		}
+		port, _ := strconv.Atoi(values["hostport"])
+		_ = binary.Write(reply, binary.LittleEndian, uint16(port))
+		reply.WriteString(values["hostip"])
+		reply.WriteByte(0)
+		return reply.Bytes()
+	}
+	reply.Write(queryFullPadding)
+	for _, key := range queryKeys {
+		reply.WriteString(key)
+		reply.WriteByte(0)
+		reply.WriteString(values[key])
+		reply.WriteByte(0)
+	}
+	reply.WriteByte(0)
+	reply.Write(queryPlayerKey)
+	// no players while sleeping, only the list terminator
+	reply.WriteByte(0)
+	return reply.Bytes()
+}
+
+// update caches the values from a stat response sent by the backend
+func (c *queryCache) update(data []byte) {
+	if len(data) < 5 || data[0] != queryTypeStat {
+		return
+	}
+	body := data[5:]
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if bytes.HasPrefix(body, queryFullPadding) {
+		body = body[len(queryFullPadding):]
+		for {
+			key, rest, ok := bytes.Cut(body, []byte{0})
+			if !ok || len(key) == 0 {
+				return
+			}
+			value, rest, ok := bytes.Cut(rest, []byte{0})
+			if !ok {
+				return
+			}
+			c.values[string(key)] = string(value)
+			body = rest
+		}
+	}
+	fields := bytes.SplitN(body, []byte{0}, 6)
+	if len(fields) < 6 || len(fields[5]) < 2 {
+		return
+	}
+	c.values["gametype"] = string(fields[1])
+	c.values["maxplayers"] = string(fields[4])
+	c.values["hostip"] = string(bytes.TrimRight(fields[5][2:], "\x00"))
+}
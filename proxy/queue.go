@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Botond24/CraftyProxy/crafty"
+	"github.com/Tnze/go-mc/data/packetid"
+	mcnet "github.com/Tnze/go-mc/net"
+	pk "github.com/Tnze/go-mc/net/packet"
+)
+
+var (
+	// QueuePriority is the players who skip ahead of everyone else in join queues
+	QueuePriority []string
+	// QueueTimeout is the longest a player is held in a join queue
+	QueueTimeout = 10 * time.Minute
+)
+
+const (
+	queuePollInterval   = time.Second
+	queueUpdateInterval = 5 * time.Second
+	// modQueueChannel carries position updates for a client mod, vanilla clients ignore it
+	modQueueChannel = "craftyproxy:queue"
+	// protocol of 1.13, the first version with login plugin requests
+	protocolLoginPlugin = 393
+)
+
+type joinQueue struct {
+	mu      sync.Mutex
+	waiting []*queueEntry
+}
+
+type queueEntry struct {
+	name     string
+	priority bool
+}
+
+var (
+	queuesMu sync.Mutex
+	queues   = map[*crafty.Server]*joinQueue{}
+)
+
+func queueFor(s *crafty.Server) *joinQueue {
+	queuesMu.Lock()
+	defer queuesMu.Unlock()
+	q, ok := queues[s]
+	if !ok {
+		q = &joinQueue{}
+		queues[s] = q
+	}
+	return q
+}
+
+// hasSlot reports whether the backend can take one more player
+func hasSlot(s *crafty.Server) bool {
+	max := s.MaxPlayers()
+	return max <= 0 || s.Players() < max
+}
+
+// admit counts the player in, holding them in the server's join queue first if it's full.
+// It returns false if the player left or timed out while waiting. Position updates while
+// waiting need a client mod, see modQueueUpdate.
+func admit(s *crafty.Server, conn net.Conn, login loginStart) bool {
+	q := queueFor(s)
+	q.mu.Lock()
+	if !s.JoinQueue || (len(q.waiting) == 0 && hasSlot(s)) {
+		s.IncrementPlayers()
+		q.mu.Unlock()
+		return true
+	}
+	entry := &queueEntry{
+		name: login.name,
+		priority: slices.ContainsFunc(QueuePriority, func(name string) bool {
+			return strings.EqualFold(name, login.name)
+		}),
+	}
+	q.add(entry)
+	position := q.position(entry)
+	q.mu.Unlock()
+	s.Logger.Println(login.name + " is waiting in the join queue at position " + strconv.Itoa(position))
+
+	c := loginConn(conn)
+	deadline := time.Now().Add(QueueTimeout)
+	var updated time.Time
+	messageID := 0
+	for {
+		q.mu.Lock()
+		position = q.position(entry)
+		if position == 1 && hasSlot(s) {
+			q.remove(entry)
+			s.IncrementPlayers()
+			q.mu.Unlock()
+			s.Logger.Println(login.name + " was admitted from the join queue")
+			return true
+		}
+		q.mu.Unlock()
+		if time.Now().After(deadline) {
+			q.leave(entry)
+			_ = disconnect(c, "The server is still full, you were position "+strconv.Itoa(position)+" in the queue.\nPlease try again later")
+			s.Logger.Println(login.name + " timed out in the join queue")
+			return false
+		}
+		if time.Since(updated) >= queueUpdateInterval {
+			updated = time.Now()
+			err := modQueueUpdate(c, messageID, position, login.protocol)
+			messageID++
+			if err != nil {
+				q.leave(entry)
+				s.Logger.Println(login.name + " left the join queue")
+				return false
+			}
+		}
+		time.Sleep(queuePollInterval)
+	}
+}
+
+// modQueueUpdate keeps the waiting client alive with a login plugin request carrying its position.
+// Vanilla clients can't show anything while logging in: they answer the request as not understood
+// and keep showing "Logging in...", so the position is only displayed by clients with a mod
+// listening on modQueueChannel. Everyone else sees their position when they time out.
+func modQueueUpdate(c *mcnet.Conn, messageID int, position int, protocol int32) error {
+	if protocol < protocolLoginPlugin {
+		return nil
+	}
+	err := c.WritePacket(pk.Marshal(
+		packetid.ClientboundLoginCustomQuery,
+		pk.VarInt(messageID),
+		pk.Identifier(modQueueChannel),
+		pk.PluginMessageData("Position "+strconv.Itoa(position)+" in queue"),
+	))
+	if err != nil {
+		return err
+	}
+	_ = c.Socket.SetReadDeadline(time.Now().Add(queueUpdateInterval * 2))
+	defer c.Socket.SetReadDeadline(time.Time{})
+	var p pk.Packet
+	return c.ReadPacket(&p)
+}
+
+// add queues the entry, priority players going behind the other priority players
+func (q *joinQueue) add(entry *queueEntry) {
+	if !entry.priority {
+		q.waiting = append(q.waiting, entry)
+		return
+	}
+	i := slices.IndexFunc(q.waiting, func(other *queueEntry) bool {
+		return !other.priority
+	})
+	if i == -1 {
+		i = len(q.waiting)
+	}
+	q.waiting = slices.Insert(q.waiting, i, entry)
+}
+
+func (q *joinQueue) position(entry *queueEntry) int {
+	return slices.Index(q.waiting, entry) + 1
+}
+
+func (q *joinQueue) remove(entry *queueEntry) {
+	q.waiting = slices.DeleteFunc(q.waiting, func(other *queueEntry) bool {
+		return other == entry
+	})
+}
+
+func (q *joinQueue) leave(entry *queueEntry) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.remove(entry)
+}
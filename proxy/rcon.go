@@ -0,0 +1,212 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/Botond24/CraftyProxy/crafty"
+)
+
+// RCON packet types
+const (
+	rconResponse = 0
+	rconCommand  = 2
+	rconAuthResp = 2
+	rconAuth     = 3
+
+	rconMaxPacket   = 4096 + 14
+	rconWakeTimeout = 5 * time.Minute
+	rconWakePoll    = 5 * time.Second
+)
+
+type rconPacket struct {
+	id   int32
+	kind int32
+	body string
+}
+
+func readRcon(r io.Reader) (p rconPacket, err error) {
+	var length int32
+	err = binary.Read(r, binary.LittleEndian, &length)
+	if err != nil {
+		return
+	}
+	if length < 10 || length > rconMaxPacket {
+		err = errors.New("invalid rcon packet length " + strconv.Itoa(int(length)))
+		return
+	}
+	data := make([]byte, length)
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return
+	}
+	p.id = int32(binary.LittleEndian.Uint32(data[0:4]))
+	p.kind = int32(binary.LittleEndian.Uint32(data[4:8]))
+	p.body = string(data[8 : length-2])
+	return
+}
+
+func writeRcon(w io.Writer, p rconPacket) error {
+	data := make([]byte, 12, 14+len(p.body))
+	binary.LittleEndian.PutUint32(data[0:4], uint32(10+len(p.body)))
+	binary.LittleEndian.PutUint32(data[4:8], uint32(p.id))
+	binary.LittleEndian.PutUint32(data[8:12], uint32(p.kind))
+	data = append(data, p.body...)
+	data = append(data, 0, 0)
+	_, err := w.Write(data)
+	return err
+}
+
+func handleRcon(s *crafty.Server, addr string) {
+	port := s.ListenPort(s.RconPort)
+	listen, err := net.Listen("tcp", addr+":"+strconv.Itoa(port))
+	if err != nil {
+		s.Logger.Fatalf("Error starting rcon proxy: %s\n", err)
+	}
+	defer listen.Close()
+	s.Logger.Println("RCON proxy started on port " + strconv.Itoa(port))
+	for {
+		conn, err := listen.Accept()
+		if err != nil {
+			s.Logger.Println("Error accepting rcon connection: " + err.Error())
+			if s.State == "removed" {
+				return
+			}
+			continue
+		}
+		if !addrAllowed(s, conn.RemoteAddr()) {
+			s.Logger.Println("Denied rcon connection from " + conn.RemoteAddr().String())
+			conn.Close()
+			continue
+		}
+		go handleRconConnection(s, conn)
+	}
+}
+
+func handleRconConnection(s *crafty.Server, conn net.Conn) {
+	defer conn.Close()
+	remote := conn.RemoteAddr().String()
+	packets := make(chan rconPacket, 16)
+	go func() {
+		defer close(packets)
+		for {
+			p, err := readRcon(conn)
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					s.Logger.Println("Error reading rcon packet from " + remote + ": " + err.Error())
+				}
+				return
+			}
+			if p.kind == rconCommand {
+				s.Logger.Println("RCON audit: " + remote + " ran \"" + p.body + "\"")
+			}
+			packets <- p
+		}
+	}()
+	// let the reader finish once the connection is closed
+	defer func() {
+		go func() {
+			for range packets {
+			}
+		}()
+	}()
+
+	if s.IsRunning() {
+		relayRcon(s, conn, packets, "", nil)
+		return
+	}
+
+	// the server sleeps, so authenticate the client ourselves
+	auth, ok := <-packets
+	if !ok {
+		return
+	}
+	password := s.Property("rcon.password")
+	if auth.kind != rconAuth || password == "" || auth.body != password {
+		s.Logger.Println("RCON audit: " + remote + " failed to authenticate")
+		_ = writeRcon(conn, rconPacket{id: -1, kind: rconAuthResp})
+		return
+	}
+	s.Logger.Println("RCON audit: " + remote + " authenticated while the server sleeps")
+	err := writeRcon(conn, rconPacket{id: auth.id, kind: rconAuthResp})
+	if err != nil {
+		return
+	}
+	if !s.RconWake {
+		for p := range packets {
+			err = writeRcon(conn, rconPacket{id: p.id, kind: rconResponse, body: "Server is sleeping: " + statusMessage(s)})
+			if err != nil {
+				return
+			}
+		}
+		return
+	}
+
+	first, ok := <-packets
+	if !ok {
+		return
+	}
+	message, ok := wake(s, "rcon client "+remote, "", "", remote, true)
+	if !ok {
+		_ = writeRcon(conn, rconPacket{id: first.id, kind: rconResponse, body: message})
+		return
+	}
+	deadline := time.Now().Add(rconWakeTimeout)
+	for !s.IsRunning() {
+		if time.Now().After(deadline) {
+			s.Logger.Println("RCON audit: dropped queued commands from " + remote + ", the server didn't start in time")
+			_ = writeRcon(conn, rconPacket{id: first.id, kind: rconResponse, body: "Server didn't start in time"})
+			return
+		}
+		time.Sleep(rconWakePoll)
+	}
+	relayRcon(s, conn, packets, password, []rconPacket{first})
+}
+
+// relayRcon forwards client packets to the backend, authenticating first if a password is given
+// and sending the queued packets before the live ones
+func relayRcon(s *crafty.Server, conn net.Conn, packets <-chan rconPacket, password string, queued []rconPacket) {
+	serverConn, err := net.Dial("tcp", s.Address+":"+strconv.Itoa(s.BackendPort(s.RconPort)))
+	if err != nil {
+		s.Logger.Println("Error connecting to rcon: " + err.Error())
+		return
+	}
+	defer serverConn.Close()
+	if password != "" {
+		err = writeRcon(serverConn, rconPacket{id: 0, kind: rconAuth, body: password})
+		if err != nil {
+			s.Logger.Println("Error authenticating to rcon: " + err.Error())
+			return
+		}
+		resp, err := readRcon(serverConn)
+		if err != nil || resp.id == -1 {
+			s.Logger.Println("Error authenticating to rcon: backend refused the password")
+			return
+		}
+	}
+	go func() {
+		_, err := io.Copy(conn, serverConn)
+		if err != nil {
+			s.Logger.Println("Error copying rcon data: " + err.Error())
+		}
+		conn.Close()
+	}()
+	for _, p := range queued {
+		err = writeRcon(serverConn, p)
+		if err != nil {
+			s.Logger.Println("Error writing to rcon: " + err.Error())
+			return
+		}
+	}
+	for p := range packets {
+		err = writeRcon(serverConn, p)
+		if err != nil {
+			s.Logger.Println("Error writing to rcon: " + err.Error())
+			return
+		}
+	}
+}
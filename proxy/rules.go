@@ -0,0 +1,193 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Botond24/CraftyProxy/crafty"
+)
+
+const rulesPollInterval = 5 * time.Second
+
+// RuleSet is a set of IP and player rules, either global or for a single server
+type RuleSet struct {
+	// Allow, if not empty, is the only IPs or CIDRs that may connect
+	Allow []string `json:"allow"`
+	// Deny is IPs or CIDRs that may not connect
+	Deny []string `json:"deny"`
+	Bans []Ban    `json:"bans"`
+
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// Ban denies a player by name or UUID, until Expires if it's set
+type Ban struct {
+	Name    string    `json:"name"`
+	UUID    string    `json:"uuid"`
+	Reason  string    `json:"reason"`
+	Expires time.Time `json:"expires"`
+}
+
+// Rules is the content of the rules file, servers being keyed by name
+type Rules struct {
+	Global  RuleSet             `json:"global"`
+	Servers map[string]*RuleSet `json:"servers"`
+}
+
+var (
+	rulesMu sync.RWMutex
+	rules   = &Rules{}
+)
+
+// WatchRules loads the rules file and reloads it whenever it changes.
+// The previous rules stay in effect if the file can't be loaded.
+func WatchRules(path string) {
+	logger := log.New(os.Stdout, "rules: ", log.Ldate|log.Ltime)
+	var modified time.Time
+	for {
+		info, err := os.Stat(path)
+		if err != nil {
+			logger.Println("Can't read rules file: " + err.Error())
+		} else if !info.ModTime().Equal(modified) {
+			modified = info.ModTime()
+			loaded, err := loadRules(path)
+			if err != nil {
+				logger.Println("Can't load rules file: " + err.Error())
+			} else {
+				rulesMu.Lock()
+				rules = loaded
+				rulesMu.Unlock()
+				logger.Println("Loaded rules from " + path)
+			}
+		}
+		time.Sleep(rulesPollInterval)
+	}
+}
+
+func loadRules(path string) (*Rules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	loaded := &Rules{}
+	err = json.Unmarshal(data, loaded)
+	if err != nil {
+		return nil, err
+	}
+	err = loaded.Global.parse()
+	if err != nil {
+		return nil, err
+	}
+	servers := make(map[string]*RuleSet, len(loaded.Servers))
+	for name, set := range loaded.Servers {
+		err = set.parse()
+		if err != nil {
+			return nil, err
+		}
+		servers[strings.ToLower(name)] = set
+	}
+	loaded.Servers = servers
+	return loaded, nil
+}
+
+func (r *RuleSet) parse() (err error) {
+	r.allow, err = parsePrefixes(r.Allow)
+	if err != nil {
+		return
+	}
+	r.deny, err = parsePrefixes(r.Deny)
+	return
+}
+
+// parsePrefixes parses CIDRs, plain addresses becoming single address prefixes
+func parsePrefixes(list []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, entry := range list {
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// ruleSets returns the global and the server's rules
+func ruleSets(s *crafty.Server) []*RuleSet {
+	rulesMu.RLock()
+	defer rulesMu.RUnlock()
+	sets := []*RuleSet{&rules.Global}
+	if set, ok := rules.Servers[strings.ToLower(s.Name)]; ok {
+		sets = append(sets, set)
+	}
+	return sets
+}
+
+// addrAllowed checks a remote address against the allow and deny lists
+func addrAllowed(s *crafty.Server, remote net.Addr) bool {
+	addrPort, err := netip.ParseAddrPort(remote.String())
+	if err != nil {
+		return true
+	}
+	addr := addrPort.Addr().Unmap()
+	for _, set := range ruleSets(s) {
+		if len(set.allow) > 0 && !containsAddr(set.allow, addr) {
+			return false
+		}
+		if containsAddr(set.deny, addr) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// findBan returns the active ban matching the player, nil if there's none
+func findBan(s *crafty.Server, name string, id string) *Ban {
+	now := time.Now()
+	for _, set := range ruleSets(s) {
+		for i, ban := range set.Bans {
+			if !ban.Expires.IsZero() && ban.Expires.Before(now) {
+				continue
+			}
+			if (ban.Name != "" && strings.EqualFold(ban.Name, name)) || (ban.UUID != "" && strings.EqualFold(ban.UUID, id)) {
+				return &set.Bans[i]
+			}
+		}
+	}
+	return nil
+}
+
+func (b *Ban) message() string {
+	message := "You are banned from this server"
+	if b.Reason != "" {
+		message += ": " + b.Reason
+	}
+	if b.Expires.IsZero() {
+		return message + "\nThis ban is permanent"
+	}
+	return message + "\nThis ban expires on " + b.Expires.Local().Format("2006-01-02 15:04 MST")
+}
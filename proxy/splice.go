@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	// KeepAlive is the TCP keepalive period of proxied connections, 0 disables keepalive
+	KeepAlive = 15 * time.Second
+	// NoDelay disables Nagle's algorithm on proxied connections
+	NoDelay = true
+)
+
+const (
+	copyBufferSize  = 32 * 1024
+	spliceChunkSize = 256 * 1024
+)
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		buffer := make([]byte, copyBufferSize)
+		return &buffer
+	},
+}
+
+// tuneConn applies the keepalive and nodelay settings to a TCP connection
+func tuneConn(conn net.Conn) {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	_ = tcp.SetNoDelay(NoDelay)
+	if KeepAlive > 0 {
+		_ = tcp.SetKeepAlive(true)
+		_ = tcp.SetKeepAlivePeriod(KeepAlive)
+	} else {
+		_ = tcp.SetKeepAlive(false)
+	}
+}
+
+// pipe copies src to dst until src is done, then half-closes dst so the other side sees EOF
+// while the opposite direction keeps flowing. TCP to TCP copies use splice, anything else a pooled buffer.
+// count, if set, is called with the bytes copied as the copy goes on, after every read
+// if zeroCopy is false, which is needed to follow small, sparse traffic.
+func pipe(dst, src net.Conn, zeroCopy bool, count func(n int64)) (n int64, err error) {
+	dstTCP, dstOk := dst.(*net.TCPConn)
+	_, srcOk := src.(*net.TCPConn)
+	if zeroCopy && dstOk && srcOk {
+		n, err = spliceCopy(dstTCP, src, count)
+	} else {
+		buffer := bufferPool.Get().(*[]byte)
+		n, err = bufferCopy(dst, src, *buffer, count)
+		bufferPool.Put(buffer)
+	}
+	closeWrite(dst)
+	if errors.Is(err, net.ErrClosed) {
+		err = nil
+	}
+	return
+}
+
+// spliceCopy copies in chunks, so the byte count can be reported while the connection is alive
+func spliceCopy(dst *net.TCPConn, src net.Conn, count func(n int64)) (n int64, err error) {
+	for {
+		var written int64
+		written, err = dst.ReadFrom(&io.LimitedReader{R: src, N: spliceChunkSize})
+		n += written
+		if count != nil && written > 0 {
+			count(written)
+		}
+		if err != nil || written < spliceChunkSize {
+			return
+		}
+	}
+}
+
+func bufferCopy(dst io.Writer, src io.Reader, buffer []byte, count func(n int64)) (n int64, err error) {
+	for {
+		read, readErr := src.Read(buffer)
+		if read > 0 {
+			written, writeErr := dst.Write(buffer[:read])
+			n += int64(written)
+			if count != nil {
+				count(int64(written))
+			}
+			if writeErr != nil {
+				return n, writeErr
+			}
+		}
+		if readErr != nil {
+			if readErr == io.EOF {
+				return n, nil
+			}
+			return n, readErr
+		}
+	}
+}
+
+func closeWrite(conn net.Conn) {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		_ = c.CloseWrite()
+		return
+	}
+	_ = conn.Close()
+}
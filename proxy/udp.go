@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Botond24/CraftyProxy/crafty"
+)
+
+const (
+	udpBufferSize     = 2048
+	udpSessionTimeout = 30 * time.Second
+	stateCacheTime    = 5 * time.Second
+)
+
+// udpRelay forwards datagrams between the clients of a public UDP socket and
+// the backend, keeping a separate upstream socket per client, so replies can
+// be mapped back to the right client.
+type udpRelay struct {
+	s        *crafty.Server
+	listener *net.UDPConn
+	backend  *net.UDPAddr
+	mu       sync.Mutex
+	sessions map[string]*udpSession
+	// onReply is called with every datagram the backend sends, if set
+	onReply func(data []byte)
+}
+
+type udpSession struct {
+	client   *net.UDPAddr
+	upstream *net.UDPConn
+	lastSeen time.Time
+}
+
+func newUDPRelay(s *crafty.Server, listener *net.UDPConn, backend *net.UDPAddr) *udpRelay {
+	return &udpRelay{
+		s:        s,
+		listener: listener,
+		backend:  backend,
+		sessions: map[string]*udpSession{},
+	}
+}
+
+// has reports whether the client already has a relay session
+func (r *udpRelay) has(client *net.UDPAddr) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.sessions[client.String()]
+	return ok
+}
+
+// forward sends a datagram from the client to the backend, opening a session if needed
+func (r *udpRelay) forward(client *net.UDPAddr, data []byte) {
+	r.mu.Lock()
+	session, ok := r.sessions[client.String()]
+	if !ok {
+		upstream, err := net.DialUDP("udp", nil, r.backend)
+		if err != nil {
+			r.mu.Unlock()
+			r.s.Logger.Println("Error connecting to udp backend: " + err.Error())
+			return
+		}
+		session = &udpSession{client: client, upstream: upstream}
+		r.sessions[client.String()] = session
+		go r.reply(session)
+	}
+	session.lastSeen = time.Now()
+	r.mu.Unlock()
+	n, err := session.upstream.Write(data)
+	if err != nil {
+		r.s.Logger.Println("Error writing to udp backend: " + err.Error())
+	}
+	r.s.AddTraffic(nil, int64(n), 0)
+}
+
+// reply relays backend datagrams to the client until the session goes idle
+func (r *udpRelay) reply(session *udpSession) {
+	defer func() {
+		r.mu.Lock()
+		delete(r.sessions, session.client.String())
+		r.mu.Unlock()
+		session.upstream.Close()
+	}()
+	buffer := make([]byte, udpBufferSize)
+	for {
+		_ = session.upstream.SetReadDeadline(time.Now().Add(udpSessionTimeout))
+		n, err := session.upstream.Read(buffer)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				r.mu.Lock()
+				idle := time.Since(session.lastSeen) >= udpSessionTimeout
+				r.mu.Unlock()
+				if idle {
+					return
+				}
+				continue
+			}
+			r.s.Logger.Println("Error reading from udp backend: " + err.Error())
+			return
+		}
+		if r.onReply != nil {
+			r.onReply(buffer[:n])
+		}
+		n, err = r.listener.WriteToUDP(buffer[:n], session.client)
+		if err != nil {
+			r.s.Logger.Println("Error writing to udp client: " + err.Error())
+		}
+		r.s.AddTraffic(nil, 0, int64(n))
+	}
+}
+
+// stateCache rate limits IsRunning checks for the UDP listeners,
+// which would otherwise query Crafty for every datagram
+type stateCache struct {
+	s       *crafty.Server
+	checked time.Time
+	running bool
+}
+
+func (c *stateCache) isRunning() bool {
+	if time.Since(c.checked) >= stateCacheTime {
+		c.running = c.s.IsRunning()
+		c.checked = time.Now()
+	}
+	return c.running
+}
+
+// listenUDP opens the public UDP socket for an optional listener port
+func listenUDP(s *crafty.Server, addr string, port int) (*net.UDPConn, *net.UDPAddr, error) {
+	listenAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(addr, strconv.Itoa(s.ListenPort(port))))
+	if err != nil {
+		return nil, nil, err
+	}
+	backend, err := net.ResolveUDPAddr("udp", net.JoinHostPort(s.Address, strconv.Itoa(s.BackendPort(port))))
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := net.ListenUDP("udp", listenAddr)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, backend, nil
+}
@@ -0,0 +1,28 @@
+package proxy
+
+import (
+	"github.com/Botond24/CraftyProxy/crafty"
+)
+
+// wake is how players start a server, whether they join from Java, connect from Bedrock
+// or wake it over RCON. It asks the wake policy, fallback being the answer without one,
+// then applies the start confirmations. Player is empty if the client can't be identified,
+// which isn't enough for the confirmations. It returns what to tell the client, ok being
+// false if the server isn't starting.
+func wake(s *crafty.Server, initiator string, player string, id string, addr string, fallback bool) (message string, ok bool) {
+	if !s.Decide(crafty.DecisionWake, fallback, player, id, addr) {
+		return messageOff, false
+	}
+	if player == "" && needsConfirmation(s) {
+		s.Logger.Println("Not starting server for " + initiator + ", starting it needs a player to confirm")
+		return messageConfirm, false
+	}
+	if status := confirmStart(s, player); status != "" {
+		return status, false
+	}
+	err := s.Start(initiator)
+	if err != nil {
+		return err.Error(), false
+	}
+	return messageOn, true
+}